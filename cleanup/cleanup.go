@@ -0,0 +1,260 @@
+// Package cleanup permanently removes photos: their documents, their blobs
+// once nothing else references them, and their vectors in the inference
+// service.
+package cleanup
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"photo-storage-backend/database"
+	"photo-storage-backend/messaging"
+	"photo-storage-backend/models"
+	"photo-storage-backend/renditions"
+	"photo-storage-backend/repository"
+	"photo-storage-backend/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReleaseBlob drops a reference to a content-addressed blob and deletes the
+// object and its renditions once nothing refers to it.
+func ReleaseBlob(ctx context.Context, digest, key string) {
+	if digest == "" {
+		return
+	}
+	remaining, err := repository.ReleaseBlob(ctx, digest)
+	if err != nil {
+		log.Printf("Failed to release blob %s: %v", digest, err)
+		return
+	}
+	if remaining == 0 {
+		deleteObjects(ctx, key)
+		if err := repository.FinishBlobDeletion(ctx, digest); err != nil {
+			log.Printf("Failed to remove blob record %s: %v", digest, err)
+		}
+	}
+}
+
+func deleteObjects(ctx context.Context, key string) {
+	store := storage.GetStore()
+	for _, k := range append([]string{key}, renditions.Keys(key)...) {
+		if err := store.Delete(ctx, k); err != nil {
+			log.Printf("Failed to delete blob %s: %v", k, err)
+		}
+	}
+}
+
+// PurgePhotos permanently deletes the photos that still match cond, e.g.
+// that are still in the trash, and returns them. Photos from before content
+// addressing own their file outright, everything else goes through the blob
+// reference count.
+func PurgePhotos(ctx context.Context, photos []models.Photo, cond bson.M) ([]models.Photo, error) {
+	if len(photos) == 0 {
+		return nil, nil
+	}
+	photos, err := deletePhotos(ctx, photos, cond)
+
+	byUser := make(map[primitive.ObjectID][]models.Photo)
+	for _, p := range photos {
+		byUser[p.UserID] = append(byUser[p.UserID], p)
+	}
+
+	rmqURL := messaging.RabbitURL()
+	for userID, userPhotos := range byUser {
+		if err := messaging.PublishEmbeddingDeletion(rmqURL, userID.Hex(), userPhotos); err != nil {
+			log.Printf("Failed to publish embedding deletion for user %s: %v", userID.Hex(), err)
+		}
+	}
+	return photos, err
+}
+
+// deletePhotos removes the photo documents that still match cond, their
+// album references and their files, and returns the photos it removed.
+// Only those are released, so a purge racing another one, or a restore,
+// never drops a blob reference or usage twice. Vectors are left to the
+// caller.
+func deletePhotos(ctx context.Context, photos []models.Photo, cond bson.M) ([]models.Photo, error) {
+	collection := database.GetPhotoCollection()
+
+	var deleted []models.Photo
+	for _, p := range photos {
+		filter := bson.M{"_id": p.ID}
+		for k, v := range cond {
+			filter[k] = v
+		}
+		res, err := collection.DeleteOne(ctx, filter)
+		if err != nil {
+			// Release what is gone so far, the rest is retried later
+			releasePhotos(ctx, deleted)
+			return deleted, err
+		}
+		if res.DeletedCount == 1 {
+			deleted = append(deleted, p)
+		}
+	}
+	releasePhotos(ctx, deleted)
+	return deleted, nil
+}
+
+// releasePhotos cleans up after photo documents that are gone.
+func releasePhotos(ctx context.Context, photos []models.Photo) {
+	if len(photos) == 0 {
+		return
+	}
+	releaseUsage(ctx, photos)
+
+	ids := make([]primitive.ObjectID, len(photos))
+	for i, p := range photos {
+		ids[i] = p.ID
+	}
+
+	albums := database.GetAlbumCollection()
+	if _, err := albums.UpdateMany(ctx,
+		bson.M{"cover_photo_id": bson.M{"$in": ids}},
+		bson.M{"$unset": bson.M{"cover_photo_id": ""}},
+	); err != nil {
+		log.Printf("Failed to clear album covers: %v", err)
+	}
+	if _, err := albums.UpdateMany(ctx,
+		bson.M{"photo_ids": bson.M{"$in": ids}},
+		bson.M{"$pull": bson.M{"photo_ids": bson.M{"$in": ids}}},
+	); err != nil {
+		log.Printf("Failed to remove purged photos from albums: %v", err)
+	}
+
+	for _, p := range photos {
+		if p.Hash != "" {
+			ReleaseBlob(ctx, p.Hash, p.Path)
+		} else {
+			deleteObjects(ctx, p.Path)
+		}
+	}
+}
+
+// releaseUsage takes deleted photos off their owners' usage.
+func releaseUsage(ctx context.Context, photos []models.Photo) {
+	type usage struct{ bytes, photos int64 }
+	byUser := make(map[primitive.ObjectID]usage)
+	for _, p := range photos {
+		u := byUser[p.UserID]
+		u.bytes += p.Size
+		u.photos++
+		byUser[p.UserID] = u
+	}
+
+	for userID, u := range byUser {
+		if err := repository.AddUsage(ctx, userID, -u.bytes, -u.photos); err != nil {
+			log.Printf("Failed to update usage of user %s: %v", userID.Hex(), err)
+		}
+	}
+}
+
+// TrashRetention is how long trashed photos are kept before the purger
+// removes them for good.
+func TrashRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days < 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// StartTrashPurger periodically purges photos that have been in the trash
+// longer than TrashRetention.
+func StartTrashPurger(interval time.Duration) {
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+			if err := purgeExpired(ctx); err != nil {
+				log.Printf("Trash purge failed: %v", err)
+			}
+			cancel()
+			time.Sleep(interval)
+		}
+	}()
+	log.Println("Trash purger started...")
+}
+
+func purgeExpired(ctx context.Context) error {
+	cutoff := time.Now().Add(-TrashRetention()).Unix()
+	collection := database.GetPhotoCollection()
+	expired := bson.M{"deleted_at": bson.M{"$lte": cutoff}}
+
+	for {
+		cursor, err := collection.Find(ctx, expired, options.Find().SetLimit(500))
+		if err != nil {
+			return err
+		}
+		var photos []models.Photo
+		if err := cursor.All(ctx, &photos); err != nil {
+			return err
+		}
+		if len(photos) == 0 {
+			return nil
+		}
+
+		purged, err := PurgePhotos(ctx, photos, expired)
+		if err != nil {
+			return err
+		}
+		log.Printf("Purged %d photos from trash", len(purged))
+	}
+}
+
+// StartUploadSweeper periodically drops expired resumable uploads. Ones
+// that never finished also give back their temp file and reserved quota.
+func StartUploadSweeper(interval time.Duration) {
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			if err := sweepUploads(ctx); err != nil {
+				log.Printf("Upload sweep failed: %v", err)
+			}
+			cancel()
+			time.Sleep(interval)
+		}
+	}()
+	log.Println("Upload sweeper started...")
+}
+
+func sweepUploads(ctx context.Context) error {
+	collection := database.GetUploadCollection()
+
+	cursor, err := collection.Find(ctx, bson.M{"expires_at": bson.M{"$lte": time.Now().Unix()}})
+	if err != nil {
+		return err
+	}
+	var uploads []models.Upload
+	if err := cursor.All(ctx, &uploads); err != nil {
+		return err
+	}
+
+	for i := range uploads {
+		if uploads[i].Status == models.UploadStatusUploading {
+			if _, err := repository.DiscardUpload(ctx, &uploads[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		// Only the document goes. A processing upload's quota is settled
+		// by whoever is finishing it, or was lost with its process.
+		if _, err := collection.DeleteOne(ctx, bson.M{"_id": uploads[i].ID}); err != nil {
+			return err
+		}
+		if uploads[i].Status == models.UploadStatusProcessing {
+			if err := os.Remove(storage.UploadPath(uploads[i].ID.Hex())); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove upload file %s: %v", uploads[i].ID.Hex(), err)
+			}
+		}
+	}
+	if len(uploads) > 0 {
+		log.Printf("Swept %d expired uploads", len(uploads))
+	}
+	return nil
+}
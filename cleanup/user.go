@@ -0,0 +1,132 @@
+package cleanup
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"photo-storage-backend/database"
+	"photo-storage-backend/messaging"
+	"photo-storage-backend/models"
+	"photo-storage-backend/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PurgeDeletedUser removes the data of an account marked deleted, and
+// then the account itself.
+func PurgeDeletedUser(ctx context.Context, userID primitive.ObjectID) error {
+	if err := PurgeUser(ctx, userID); err != nil {
+		return err
+	}
+	if _, err := database.GetUserCollection().DeleteOne(ctx, bson.M{"_id": userID, "deleted_at": bson.M{"$exists": true}}); err != nil {
+		return err
+	}
+	log.Printf("Purged data of deleted user %s", userID.Hex())
+	return nil
+}
+
+// StartUserPurger periodically retries purging deleted accounts whose
+// cleanup failed or was cut short by a restart.
+func StartUserPurger(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			if err := purgeDeletedUsers(ctx, interval); err != nil {
+				log.Printf("Deleted user purge failed: %v", err)
+			}
+			cancel()
+		}
+	}()
+	log.Println("Deleted user purger started...")
+}
+
+// purgeDeletedUsers purges accounts deleted at least minAge ago, leaving
+// fresh ones to the purge DeleteMe starts itself.
+func purgeDeletedUsers(ctx context.Context, minAge time.Duration) error {
+	ids, err := database.GetUserCollection().Distinct(ctx, "_id",
+		bson.M{"deleted_at": bson.M{"$lte": time.Now().Add(-minAge).Unix()}})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		userID, ok := id.(primitive.ObjectID)
+		if !ok {
+			continue
+		}
+		if err := PurgeDeletedUser(ctx, userID); err != nil {
+			log.Printf("Failed to purge data of deleted user %s: %v", userID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// PurgeUser removes everything a deleted account left behind: photos and
+// their files, albums, shares, notifications and tokens. The inference
+// service gets a single message to drop all of the user's vectors.
+func PurgeUser(ctx context.Context, userID primitive.ObjectID) error {
+	photos := database.GetPhotoCollection()
+	for {
+		cursor, err := photos.Find(ctx, bson.M{"user_id": userID}, options.Find().SetLimit(500))
+		if err != nil {
+			return err
+		}
+		var batch []models.Photo
+		if err := cursor.All(ctx, &batch); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		if _, err := deletePhotos(ctx, batch, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := messaging.PublishUserDeletion(messaging.RabbitURL(), userID.Hex()); err != nil {
+		log.Printf("Failed to publish user deletion for %s: %v", userID.Hex(), err)
+	}
+
+	// Albums shared with the user just lose the member
+	if _, err := database.GetAlbumCollection().UpdateMany(ctx,
+		bson.M{"members.user_id": userID},
+		bson.M{"$pull": bson.M{"members": bson.M{"user_id": userID}}},
+	); err != nil {
+		return err
+	}
+
+	// Unfinished uploads leave temp files behind
+	ids, err := database.GetUploadCollection().Distinct(ctx, "_id",
+		bson.M{"user_id": userID, "status": models.UploadStatusUploading})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			if err := os.Remove(storage.UploadPath(oid.Hex())); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove upload file %s: %v", oid.Hex(), err)
+			}
+		}
+	}
+
+	for _, collection := range []*mongo.Collection{
+		database.GetAlbumCollection(),
+		database.GetShareCollection(),
+		database.GetNotificationCollection(),
+		database.GetSessionCollection(),
+		database.GetRefreshTokenCollection(),
+		database.GetAccessTokenCollection(),
+		database.GetUserTokenCollection(),
+		database.GetUploadCollection(),
+	} {
+		if _, err := collection.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -11,6 +12,16 @@ import (
 var photoCollection *mongo.Collection
 var userCollection *mongo.Collection
 var notificationCollection *mongo.Collection
+var blobCollection *mongo.Collection
+var albumCollection *mongo.Collection
+var shareCollection *mongo.Collection
+var sessionCollection *mongo.Collection
+var refreshTokenCollection *mongo.Collection
+var userTokenCollection *mongo.Collection
+var accessTokenCollection *mongo.Collection
+var loginAttemptCollection *mongo.Collection
+var authAuditCollection *mongo.Collection
+var uploadCollection *mongo.Collection
 
 func InitMongo(uri, dbName string) {
 	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
@@ -20,6 +31,97 @@ func InitMongo(uri, dbName string) {
 	photoCollection = client.Database(dbName).Collection("photos")
 	userCollection = client.Database(dbName).Collection("users")
 	notificationCollection = client.Database(dbName).Collection("notifications")
+	blobCollection = client.Database(dbName).Collection("blobs")
+	albumCollection = client.Database(dbName).Collection("albums")
+	shareCollection = client.Database(dbName).Collection("shares")
+	sessionCollection = client.Database(dbName).Collection("sessions")
+	refreshTokenCollection = client.Database(dbName).Collection("refresh_tokens")
+	userTokenCollection = client.Database(dbName).Collection("user_tokens")
+	accessTokenCollection = client.Database(dbName).Collection("access_tokens")
+	loginAttemptCollection = client.Database(dbName).Collection("login_attempts")
+	authAuditCollection = client.Database(dbName).Collection("auth_audit")
+	uploadCollection = client.Database(dbName).Collection("uploads")
+
+	ensureIndexes(context.Background())
+}
+
+func ensureIndexes(ctx context.Context) {
+	indexes := map[*mongo.Collection][]mongo.IndexModel{
+		photoCollection: {
+			// One photo per content hash per user, older photos without a
+			// hash are left alone.
+			{
+				Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "hash", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"hash": bson.M{"$type": "string"}}),
+			},
+			// Gallery sort orders
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "upload_at", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "taken_at", Value: -1}}},
+			// Tag and favorite filters
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "tags", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "favorite", Value: 1}}},
+			// Trash listing and the purger
+			{Keys: bson.D{{Key: "deleted_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+		},
+		albumCollection: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "updated_at", Value: -1}}},
+			// Cleanup when photos are purged
+			{Keys: bson.D{{Key: "photo_ids", Value: 1}}},
+			// Albums shared with a user
+			{Keys: bson.D{{Key: "members.user_id", Value: 1}}},
+		},
+		shareCollection: {
+			{Keys: bson.D{{Key: "token", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+		sessionCollection: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+		refreshTokenCollection: {
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "session_id", Value: 1}}},
+			// Expired tokens are useless, even for reuse detection
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		userTokenCollection: {
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}}},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		accessTokenCollection: {
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+		loginAttemptCollection: {
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		authAuditCollection: {
+			{Keys: bson.D{{Key: "email", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "ip", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+		uploadCollection: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			// Expired uploads are swept by cleanup, which also frees their
+			// temp files and reserved quota
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}},
+		},
+		userCollection: {
+			{Keys: bson.D{{Key: "email", Value: 1}}},
+			// An external identity signs in to one account only
+			{
+				Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"identities.subject": bson.M{"$type": "string"}}),
+			},
+		},
+	}
+
+	for collection, models := range indexes {
+		if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+			log.Printf("Failed to create indexes on %s: %v", collection.Name(), err)
+		}
+	}
 }
 
 func GetPhotoCollection() *mongo.Collection {
@@ -33,3 +135,43 @@ func GetUserCollection() *mongo.Collection {
 func GetNotificationCollection() *mongo.Collection {
 	return notificationCollection
 }
+
+func GetBlobCollection() *mongo.Collection {
+	return blobCollection
+}
+
+func GetAlbumCollection() *mongo.Collection {
+	return albumCollection
+}
+
+func GetShareCollection() *mongo.Collection {
+	return shareCollection
+}
+
+func GetSessionCollection() *mongo.Collection {
+	return sessionCollection
+}
+
+func GetRefreshTokenCollection() *mongo.Collection {
+	return refreshTokenCollection
+}
+
+func GetUserTokenCollection() *mongo.Collection {
+	return userTokenCollection
+}
+
+func GetAccessTokenCollection() *mongo.Collection {
+	return accessTokenCollection
+}
+
+func GetLoginAttemptCollection() *mongo.Collection {
+	return loginAttemptCollection
+}
+
+func GetAuthAuditCollection() *mongo.Collection {
+	return authAuditCollection
+}
+
+func GetUploadCollection() *mongo.Collection {
+	return uploadCollection
+}
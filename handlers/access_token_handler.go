@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+	"photo-storage-backend/repository"
+	"photo-storage-backend/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ListAccessTokens lists the caller's active personal access tokens.
+func ListAccessTokens(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	cursor, err := database.GetAccessTokenCollection().Find(context.Background(),
+		bson.M{
+			"user_id":    userID,
+			"revoked_at": bson.M{"$exists": false},
+			"$or": []bson.M{
+				{"expires_at": bson.M{"$exists": false}},
+				{"expires_at": bson.M{"$gt": time.Now().Unix()}},
+			},
+		},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch tokens"})
+		return
+	}
+
+	tokens := []models.AccessToken{}
+	if err := cursor.All(context.Background(), &tokens); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode tokens"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// CreateAccessToken creates a personal access token. The token itself is
+// only in this response; we keep its hash.
+func CreateAccessToken(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	var body struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn int64    `json:"expires_in"` // seconds, 0 for no expiry
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if len(body.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at least one scope is required"})
+		return
+	}
+	for _, scope := range body.Scopes {
+		if !slices.Contains(models.AccessTokenScopes, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown scope " + scope, "scopes": models.AccessTokenScopes})
+			return
+		}
+	}
+	if body.ExpiresIn < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in must not be negative"})
+		return
+	}
+
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+		return
+	}
+	raw := models.AccessTokenPrefix + secret
+
+	now := time.Now()
+	token := models.AccessToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Name:      body.Name,
+		Prefix:    raw[:len(models.AccessTokenPrefix)+6],
+		TokenHash: utils.HashToken(raw),
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(body.Scopes))),
+		CreatedAt: now.Unix(),
+	}
+	if body.ExpiresIn > 0 {
+		token.ExpiresAt = now.Add(time.Duration(body.ExpiresIn) * time.Second).Unix()
+	}
+
+	if _, err := database.GetAccessTokenCollection().InsertOne(context.Background(), token); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"token": raw, "access_token": token})
+}
+
+// RevokeAccessToken revokes one of the caller's personal access tokens.
+func RevokeAccessToken(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	tokenID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token ID"})
+		return
+	}
+
+	revoked, err := repository.RevokeAccessToken(c.Request.Context(), userID, tokenID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke token"})
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
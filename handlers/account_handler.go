@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/mail"
+	"photo-storage-backend/cleanup"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+	"photo-storage-backend/repository"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GetMe returns the caller's profile.
+func GetMe(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	fillUser(user)
+	c.JSON(http.StatusOK, user)
+}
+
+// UpdateMe changes the caller's name, email or password. Changing the email
+// or password takes the current password, for accounts that have one. A new
+// email has to be verified again.
+func UpdateMe(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Name            *string `json:"name"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	set := bson.M{}
+	if body.Name != nil {
+		name := strings.TrimSpace(*body.Name)
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name must not be empty"})
+			return
+		}
+		set["name"] = name
+	}
+
+	emailChanged := false
+	if body.Email != nil && strings.TrimSpace(*body.Email) != user.Email {
+		email := strings.TrimSpace(*body.Email)
+		if _, err := mail.ParseAddress(email); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email format"})
+			return
+		}
+		count, err := database.GetUserCollection().CountDocuments(context.Background(), bson.M{"email": email})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update account"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "email already in use"})
+			return
+		}
+		set["email"] = email
+		set["email_verified"] = false
+		emailChanged = true
+	}
+
+	if (emailChanged || body.Password != nil) && !checkPassword(user, body.CurrentPassword) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "wrong password"})
+		return
+	}
+
+	if body.Password != nil {
+		if err := validatePassword(*body.Password); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		hashed, err := bcrypt.GenerateFromPassword([]byte(*body.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update account"})
+			return
+		}
+		set["password"] = string(hashed)
+	}
+
+	if len(set) > 0 {
+		if _, err := database.GetUserCollection().UpdateByID(context.Background(), user.ID, bson.M{"$set": set}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update account"})
+			return
+		}
+	}
+
+	if name, ok := set["name"].(string); ok {
+		user.Name = name
+	}
+	if emailChanged {
+		user.Email = set["email"].(string)
+		user.EmailVerified = false
+
+		// Links sent to the old address must not work any more
+		if err := repository.DeleteUserTokens(c.Request.Context(), user.ID,
+			models.TokenPurposeResetPassword, models.TokenPurposeVerifyEmail); err != nil {
+			log.Printf("Failed to delete tokens after email change: %v", err)
+		}
+		if err := sendVerificationEmail(c.Request.Context(), user); err != nil {
+			log.Printf("Failed to send verification email: %v", err)
+		}
+	}
+	if hashed, ok := set["password"].(string); ok {
+		user.Password = hashed
+
+		// Log out every other device, they may know the old password
+		var except *primitive.ObjectID
+		if sessionID, err := primitive.ObjectIDFromHex(c.GetString("sessionID")); err == nil {
+			except = &sessionID
+		}
+		if _, err := repository.RevokeUserSessions(c.Request.Context(), user.ID, except, "password changed"); err != nil {
+			log.Printf("Failed to revoke sessions after password change: %v", err)
+		}
+	}
+
+	fillUser(user)
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteMe deletes the caller's account. It takes the password, for
+// accounts that have one, and a code when two-factor auth is on. Signing in
+// and share links stop working right away; photos and other data are
+// removed in the background by cleanup, which retries until it is done.
+func DeleteMe(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if !checkPassword(user, body.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "wrong password"})
+		return
+	}
+	if user.MFAEnabled() {
+		if !checkMFACode(c, user, body.Code) {
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	if _, err := repository.RevokeUserSessions(ctx, user.ID, nil, "account deleted"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete account"})
+		return
+	}
+	if err := repository.RevokeUserAccessTokens(ctx, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete account"})
+		return
+	}
+	for _, collection := range []*mongo.Collection{database.GetShareCollection(), database.GetUserTokenCollection()} {
+		if _, err := collection.DeleteMany(ctx, bson.M{"user_id": user.ID}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete account"})
+			return
+		}
+	}
+
+	// Take away everything the account could be found or signed in by,
+	// the document itself goes with the rest of the data
+	if _, err := database.GetUserCollection().UpdateByID(ctx, user.ID, bson.M{
+		"$set":   bson.M{"deleted_at": time.Now().Unix()},
+		"$unset": bson.M{"email": "", "password": "", "identities": "", "mfa": ""},
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete account"})
+		return
+	}
+
+	go func(userID primitive.ObjectID) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		defer cancel()
+		if err := cleanup.PurgeDeletedUser(ctx, userID); err != nil {
+			log.Printf("Failed to purge data of deleted user %s, will retry: %v", userID.Hex(), err)
+		}
+	}(user.ID)
+
+	c.JSON(http.StatusAccepted, gin.H{"status": "ok"})
+}
+
+// checkPassword checks the password of accounts that have one. Accounts
+// that only sign in with an external provider have nothing to check.
+func checkPassword(user *models.User, password string) bool {
+	if user.Password == "" {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+}
+
+func fillUser(user *models.User) {
+	user.HasPassword = user.Password != ""
+	user.TwoFactor = user.MFAEnabled()
+	user.Role = user.UserRole()
+}
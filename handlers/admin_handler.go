@@ -0,0 +1,442 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"photo-storage-backend/database"
+	"photo-storage-backend/messaging"
+	"photo-storage-backend/models"
+	"photo-storage-backend/repository"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Photos per embedding job when re-embedding a whole library
+const reembedBatchSize = 100
+
+type photoUsage struct {
+	UserID       primitive.ObjectID `bson:"_id" json:"user_id"`
+	Photos       int64              `bson:"photos" json:"photos"`
+	Bytes        int64              `bson:"bytes" json:"bytes"`
+	TrashedCount int64              `bson:"trashed" json:"trashed"`
+	TrashedBytes int64              `bson:"trashed_bytes" json:"trashed_bytes"`
+}
+
+// AdminListUsers lists accounts, optionally filtered by a search on name
+// and email.
+func AdminListUsers(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	filter := bson.M{"deleted_at": bson.M{"$exists": false}}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(q), Options: "i"}
+		filter["$or"] = []bson.M{{"email": pattern}, {"name": pattern}}
+	}
+	if role := c.Query("role"); role != "" {
+		if role == models.UserRoleUser {
+			filter["role"] = bson.M{"$in": bson.A{nil, models.UserRoleUser}}
+		} else {
+			filter["role"] = role
+		}
+	}
+	if c.Query("disabled") == "true" {
+		filter["disabled_at"] = bson.M{"$exists": true}
+	}
+
+	collection := database.GetUserCollection()
+	total, err := collection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count users"})
+		return
+	}
+
+	cursor, err := collection.Find(context.Background(), filter,
+		options.Find().
+			SetSort(bson.D{{Key: "_id", Value: -1}}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch users"})
+		return
+	}
+	users := []models.User{}
+	if err := cursor.All(context.Background(), &users); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode users"})
+		return
+	}
+	for i := range users {
+		fillUser(&users[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"page":       page,
+		"limit":      limit,
+		"users":      users,
+		"total":      total,
+		"totalPages": int(math.Ceil(float64(total) / float64(limit))),
+	})
+}
+
+// AdminGetUser shows one account with its storage usage. usage is the
+// counter the quota is enforced against; counted_usage is summed from the
+// photos themselves, so a difference means the counter has drifted.
+func AdminGetUser(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	usage, err := storageUsage(c.Request.Context(), bson.M{"user_id": user.ID}, 1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute usage"})
+		return
+	}
+	u := photoUsage{UserID: user.ID}
+	if len(usage) > 0 {
+		u = usage[0]
+	}
+
+	fillUser(user)
+	c.JSON(http.StatusOK, gin.H{
+		"user":          user,
+		"usage":         user.Usage,
+		"counted_usage": u,
+		"quota":         repository.QuotaFor(user),
+	})
+}
+
+// AdminStorageUsage shows total storage and the accounts using the most.
+// Bytes count each user's photos in full; stored_bytes is what is actually
+// in storage after deduplication.
+func AdminStorageUsage(c *gin.Context) {
+	top, err := strconv.Atoi(c.DefaultQuery("top", "20"))
+	if err != nil || top < 1 || top > 500 {
+		top = 20
+	}
+
+	ctx := c.Request.Context()
+	users, err := storageUsage(ctx, bson.M{}, top)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute usage"})
+		return
+	}
+
+	pipeline := bson.A{
+		bson.M{"$group": bson.M{"_id": nil, "blobs": bson.M{"$sum": 1}, "bytes": bson.M{"$sum": "$size"}}},
+	}
+	cursor, err := database.GetBlobCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute usage"})
+		return
+	}
+	var blobs []struct {
+		Blobs int64 `bson:"blobs"`
+		Bytes int64 `bson:"bytes"`
+	}
+	if err := cursor.All(ctx, &blobs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute usage"})
+		return
+	}
+
+	totals := gin.H{"blobs": int64(0), "stored_bytes": int64(0)}
+	if len(blobs) > 0 {
+		totals["blobs"] = blobs[0].Blobs
+		totals["stored_bytes"] = blobs[0].Bytes
+	}
+	c.JSON(http.StatusOK, gin.H{"totals": totals, "users": users})
+}
+
+// storageUsage sums up photos per user, biggest first.
+func storageUsage(ctx context.Context, match bson.M, limit int) ([]photoUsage, error) {
+	trashed := bson.M{"$gt": bson.A{"$deleted_at", 0}}
+	pipeline := bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{
+			"_id":           "$user_id",
+			"photos":        bson.M{"$sum": 1},
+			"bytes":         bson.M{"$sum": "$size"},
+			"trashed":       bson.M{"$sum": bson.M{"$cond": bson.A{trashed, 1, 0}}},
+			"trashed_bytes": bson.M{"$sum": bson.M{"$cond": bson.A{trashed, "$size", 0}}},
+		}},
+		bson.M{"$sort": bson.M{"bytes": -1}},
+		bson.M{"$limit": limit},
+	}
+	cursor, err := database.GetPhotoCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	usage := []photoUsage{}
+	if err := cursor.All(ctx, &usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// AdminSetRole changes an account's role. Admins can't change their own,
+// so there is always at least one admin left.
+func AdminSetRole(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if body.Role != models.UserRoleUser && body.Role != models.UserRoleAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be user or admin"})
+		return
+	}
+	if user.ID.Hex() == c.GetString("userID") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you can't change your own role"})
+		return
+	}
+
+	if _, err := database.GetUserCollection().UpdateByID(context.Background(), user.ID,
+		bson.M{"$set": bson.M{"role": body.Role}}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update role"})
+		return
+	}
+	log.Printf("Admin %s set role of user %s to %s", c.GetString("userID"), user.ID.Hex(), body.Role)
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// AdminSetQuota overrides an account's quota. 0 goes back to the default,
+// -1 lifts the limit.
+func AdminSetQuota(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Bytes  *int64 `json:"bytes"`
+		Photos *int64 `json:"photos"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	set, unset := bson.M{}, bson.M{}
+	for field, v := range map[string]*int64{"quota_bytes": body.Bytes, "quota_photos": body.Photos} {
+		switch {
+		case v == nil:
+		case *v < -1:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "quotas must be -1, 0 or positive"})
+			return
+		case *v == 0:
+			unset[field] = ""
+		default:
+			set[field] = *v
+		}
+	}
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if len(update) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to update"})
+		return
+	}
+
+	var updated models.User
+	if err := database.GetUserCollection().FindOneAndUpdate(context.Background(), bson.M{"_id": user.ID}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update quota"})
+		return
+	}
+	log.Printf("Admin %s changed the quota of user %s", c.GetString("userID"), user.ID.Hex())
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "quota": repository.QuotaFor(&updated)})
+}
+
+// AdminDisableUser blocks an account from logging in and ends its
+// sessions and personal access tokens. Its share links stop working while
+// it is disabled.
+func AdminDisableUser(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+	if user.ID.Hex() == c.GetString("userID") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you can't disable your own account"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if !user.Disabled() {
+		if _, err := database.GetUserCollection().UpdateByID(ctx, user.ID,
+			bson.M{"$set": bson.M{"disabled_at": time.Now().Unix()}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable account"})
+			return
+		}
+	}
+	if _, err := repository.RevokeUserSessions(ctx, user.ID, nil, "account disabled"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+	if err := repository.RevokeUserAccessTokens(ctx, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke access tokens"})
+		return
+	}
+
+	log.Printf("Admin %s disabled user %s", c.GetString("userID"), user.ID.Hex())
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// AdminEnableUser lets a disabled account log in again. Revoked access
+// tokens stay revoked.
+func AdminEnableUser(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	if _, err := database.GetUserCollection().UpdateByID(context.Background(), user.ID,
+		bson.M{"$unset": bson.M{"disabled_at": ""}}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable account"})
+		return
+	}
+	log.Printf("Admin %s enabled user %s", c.GetString("userID"), user.ID.Hex())
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// AdminForcePasswordReset stops the current password from working, logs
+// the account out everywhere and mails the user a reset link.
+func AdminForcePasswordReset(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := database.GetUserCollection().UpdateByID(ctx, user.ID,
+		bson.M{"$set": bson.M{"password_reset_required": true}}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to require password reset"})
+		return
+	}
+	if _, err := repository.RevokeUserSessions(ctx, user.ID, nil, "password reset required"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+	if err := repository.RevokeUserAccessTokens(ctx, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke access tokens"})
+		return
+	}
+
+	body := "For your security, our support team asked you to choose a new password. Open this link to set one:\n\n%s\n\nThe link expires in 1 hour. You can request a new one from the login page."
+	if err := sendPasswordResetEmail(ctx, user, body); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send reset email"})
+		return
+	}
+
+	log.Printf("Admin %s required a password reset for user %s", c.GetString("userID"), user.ID.Hex())
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// AdminReembedUser sends all of an account's photos to the inference
+// service again, e.g. after a model change or a lost index. The user sees
+// the progress as a notification, like after an upload.
+func AdminReembedUser(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	filter := bson.M{"user_id": user.ID, "deleted_at": bson.M{"$exists": false}}
+	cursor, err := database.GetPhotoCollection().Find(ctx, filter,
+		options.Find().SetProjection(bson.M{"name": 1, "path": 1, "upload_at": 1, "user_id": 1}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch photos"})
+		return
+	}
+	var photos []models.Photo
+	if err := cursor.All(ctx, &photos); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode photos"})
+		return
+	}
+	if len(photos) == 0 {
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "photos": 0})
+		return
+	}
+
+	if _, err := database.GetPhotoCollection().UpdateMany(ctx, filter, bson.M{"$set": bson.M{"embedded": false}}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset embedding status"})
+		return
+	}
+
+	// One batch for the whole library so progress adds up in one
+	// notification, the photos keep their upload batch
+	batchID := primitive.NewObjectID()
+	for i := range photos {
+		photos[i].BatchID = batchID
+	}
+	notification := models.Notification{
+		ID:        primitive.NewObjectID(),
+		BatchID:   batchID,
+		UserID:    user.ID,
+		CreatedAt: time.Now().Unix(),
+		Status:    "pending",
+		Total:     len(photos),
+		Message:   "Re-indexing your photos...",
+	}
+	if _, err := database.GetNotificationCollection().InsertOne(ctx, notification); err != nil {
+		log.Printf("Failed to insert notification: %v", err)
+	}
+
+	go func(photos []models.Photo) {
+		for start := 0; start < len(photos); start += reembedBatchSize {
+			end := min(start+reembedBatchSize, len(photos))
+			if err := messaging.PublishEmbeddingJob(messaging.RabbitURL(), photos[start:end]); err != nil {
+				log.Printf("Failed to publish re-embedding job: %v", err)
+				return
+			}
+		}
+	}(photos)
+
+	log.Printf("Admin %s queued re-embedding of %d photos for user %s", c.GetString("userID"), len(photos), user.ID.Hex())
+	c.JSON(http.StatusAccepted, gin.H{"status": "ok", "photos": len(photos), "batch_id": batchID})
+}
+
+// adminTargetUser loads the user named in the URL, writing the error
+// response if it can't.
+func adminTargetUser(c *gin.Context) (*models.User, bool) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return nil, false
+	}
+
+	var user models.User
+	if err := database.GetUserCollection().FindOne(context.Background(),
+		bson.M{"_id": userID, "deleted_at": bson.M{"$exists": false}}).Decode(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return nil, false
+	}
+	return &user, true
+}
@@ -0,0 +1,463 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+	"photo-storage-backend/utils"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxAlbumNameLength = 100
+
+func ListAlbums(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	cursor, err := database.GetAlbumCollection().Find(context.Background(),
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch albums"})
+		return
+	}
+
+	albums := []models.Album{}
+	if err := cursor.All(context.Background(), &albums); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode albums"})
+		return
+	}
+
+	for i := range albums {
+		fillAlbum(&albums[i], userID.Hex())
+	}
+
+	c.JSON(http.StatusOK, albums)
+}
+
+func CreateAlbum(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	var body struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		PhotoIDs    []string `json:"photo_ids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" || len(name) > maxAlbumNameLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "album name must be 1-100 characters"})
+		return
+	}
+
+	photoIDs, err := ownedPhotoIDs(userID, parseObjectIDs(body.PhotoIDs))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check photos"})
+		return
+	}
+
+	now := time.Now().Unix()
+	album := models.Album{
+		ID:          primitive.NewObjectID(),
+		UserID:      userID,
+		Name:        name,
+		Description: strings.TrimSpace(body.Description),
+		PhotoIDs:    photoIDs,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if _, err := database.GetAlbumCollection().InsertOne(context.Background(), album); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create album"})
+		return
+	}
+
+	fillAlbum(&album, userID.Hex())
+	c.JSON(http.StatusOK, album)
+}
+
+func GetAlbum(c *gin.Context) {
+	album, ok := findAlbum(c, models.AlbumRoleViewer)
+	if !ok {
+		return
+	}
+
+	userIDStr, _ := c.Get("userID")
+	fillAlbum(album, userIDStr.(string))
+	c.JSON(http.StatusOK, album)
+}
+
+// UpdateAlbum renames an album, changes its description or sets its cover.
+// An empty cover_photo_id clears the cover.
+func UpdateAlbum(c *gin.Context) {
+	album, ok := findAlbum(c, models.AlbumRoleEditor)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Name         *string `json:"name"`
+		Description  *string `json:"description"`
+		CoverPhotoID *string `json:"cover_photo_id"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	set := bson.M{"updated_at": time.Now().Unix()}
+	unset := bson.M{}
+
+	if body.Name != nil {
+		name := strings.TrimSpace(*body.Name)
+		if name == "" || len(name) > maxAlbumNameLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "album name must be 1-100 characters"})
+			return
+		}
+		set["name"] = name
+	}
+	if body.Description != nil {
+		set["description"] = strings.TrimSpace(*body.Description)
+	}
+	if body.CoverPhotoID != nil {
+		if *body.CoverPhotoID == "" {
+			unset["cover_photo_id"] = ""
+		} else {
+			coverID, err := primitive.ObjectIDFromHex(*body.CoverPhotoID)
+			if err != nil || !containsID(album.PhotoIDs, coverID) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "cover photo must be in the album"})
+				return
+			}
+			set["cover_photo_id"] = coverID
+		}
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	var updated models.Album
+	err := database.GetAlbumCollection().FindOneAndUpdate(context.Background(),
+		bson.M{"_id": album.ID},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update album"})
+		return
+	}
+
+	userIDStr, _ := c.Get("userID")
+	fillAlbum(&updated, userIDStr.(string))
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteAlbum removes the album only, its photos stay in the library.
+func DeleteAlbum(c *gin.Context) {
+	album, ok := findAlbum(c, models.AlbumRoleOwner)
+	if !ok {
+		return
+	}
+
+	if _, err := database.GetAlbumCollection().DeleteOne(context.Background(), bson.M{"_id": album.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete album"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// AddAlbumPhotos appends photos to the end of the album. Photos already in
+// the album keep their position. Members can only add their own photos.
+func AddAlbumPhotos(c *gin.Context) {
+	album, ok := findAlbum(c, models.AlbumRoleContributor)
+	if !ok {
+		return
+	}
+
+	objectIDs, ok := bindObjectIDs(c)
+	if !ok {
+		return
+	}
+
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	photoIDs, err := ownedPhotoIDs(userID, objectIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check photos"})
+		return
+	}
+	if len(photoIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no valid photos provided"})
+		return
+	}
+
+	if err := addToAlbum(context.Background(), album.ID, photoIDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add photos"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "added_count": len(photoIDs)})
+}
+
+// RemoveAlbumPhotos takes photos out of the album without deleting them.
+func RemoveAlbumPhotos(c *gin.Context) {
+	album, ok := findAlbum(c, models.AlbumRoleEditor)
+	if !ok {
+		return
+	}
+
+	objectIDs, ok := bindObjectIDs(c)
+	if !ok {
+		return
+	}
+
+	update := bson.M{
+		"$pull": bson.M{"photo_ids": bson.M{"$in": objectIDs}},
+		"$set":  bson.M{"updated_at": time.Now().Unix()},
+	}
+	if album.CoverPhotoID != nil && containsID(objectIDs, *album.CoverPhotoID) {
+		update["$unset"] = bson.M{"cover_photo_id": ""}
+	}
+
+	if _, err := database.GetAlbumCollection().UpdateByID(context.Background(), album.ID, update); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove photos"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ReorderAlbum sets the manual order of the album. The IDs must be exactly
+// the photos currently in the album.
+func ReorderAlbum(c *gin.Context) {
+	album, ok := findAlbum(c, models.AlbumRoleEditor)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	order := parseObjectIDs(body.IDs)
+	if !samePhotoSet(order, album.PhotoIDs) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must list every photo in the album exactly once"})
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"photo_ids": order, "updated_at": time.Now().Unix()}}
+	if _, err := database.GetAlbumCollection().UpdateByID(context.Background(), album.ID, update); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reorder album"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// findAlbum loads the album in the :id param if the caller has at least
+// minRole on it, and writes the error response otherwise.
+func findAlbum(c *gin.Context, minRole string) (*models.Album, bool) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+	return loadAlbum(c, c.Param("id"), userID, minRole)
+}
+
+func loadAlbum(c *gin.Context, albumIDStr string, userID primitive.ObjectID, minRole string) (*models.Album, bool) {
+	albumID, err := primitive.ObjectIDFromHex(albumIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid album ID"})
+		return nil, false
+	}
+
+	filter := bson.M{"_id": albumID, "$or": bson.A{
+		bson.M{"user_id": userID},
+		bson.M{"members.user_id": userID},
+	}}
+
+	var album models.Album
+	err = database.GetAlbumCollection().FindOne(context.Background(), filter).Decode(&album)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "album not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch album"})
+		return nil, false
+	}
+
+	if albumRoleRank[albumRole(&album, userID)] < albumRoleRank[minRole] {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed for your role in this album"})
+		return nil, false
+	}
+	return &album, true
+}
+
+var albumRoleRank = map[string]int{
+	models.AlbumRoleViewer:      1,
+	models.AlbumRoleContributor: 2,
+	models.AlbumRoleEditor:      3,
+	models.AlbumRoleOwner:       4,
+}
+
+// albumRole is the user's role in the album, empty if they have none.
+func albumRole(album *models.Album, userID primitive.ObjectID) string {
+	if album.UserID == userID {
+		return models.AlbumRoleOwner
+	}
+	for _, m := range album.Members {
+		if m.UserID == userID {
+			return m.Role
+		}
+	}
+	return ""
+}
+
+// addToAlbum appends photos to the album, skipping those already in it.
+func addToAlbum(ctx context.Context, albumID primitive.ObjectID, photoIDs []primitive.ObjectID) error {
+	update := bson.M{
+		"$addToSet": bson.M{"photo_ids": bson.M{"$each": photoIDs}},
+		"$set":      bson.M{"updated_at": time.Now().Unix()},
+	}
+	_, err := database.GetAlbumCollection().UpdateByID(ctx, albumID, update)
+	return err
+}
+
+// inSharedAlbum reports whether the photo is in an album the user owns or
+// is a member of, which lets them see photos other accounts put there.
+func inSharedAlbum(ctx context.Context, photoID, userID primitive.ObjectID) (bool, error) {
+	n, err := database.GetAlbumCollection().CountDocuments(ctx, bson.M{
+		"photo_ids": photoID,
+		"$or": bson.A{
+			bson.M{"user_id": userID},
+			bson.M{"members.user_id": userID},
+		},
+	}, options.Count().SetLimit(1))
+	return n > 0, err
+}
+
+// sharedPhotoIDs lists the photos in albums other accounts share with the
+// user.
+func sharedPhotoIDs(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	cursor, err := database.GetAlbumCollection().Find(ctx,
+		bson.M{"members.user_id": userID},
+		options.Find().SetProjection(bson.M{"photo_ids": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var albums []models.Album
+	if err := cursor.All(ctx, &albums); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[primitive.ObjectID]bool)
+	var ids []primitive.ObjectID
+	for _, a := range albums {
+		for _, id := range a.PhotoIDs {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// fillAlbum sets the response-only fields. Albums without an explicit cover
+// use their first photo.
+func fillAlbum(album *models.Album, userID string) {
+	album.PhotoCount = len(album.PhotoIDs)
+
+	uid, _ := primitive.ObjectIDFromHex(userID)
+	album.Role = albumRole(album, uid)
+
+	coverID := album.CoverPhotoID
+	if coverID == nil && len(album.PhotoIDs) > 0 {
+		coverID = &album.PhotoIDs[0]
+	}
+	if coverID != nil {
+		album.CoverURL = thumbnailURL(coverID.Hex(), userID, utils.SignedURLTTL())
+	}
+}
+
+// ownedPhotoIDs keeps the IDs of photos that belong to the user and are not
+// in the trash, in the order given, without repeats.
+func ownedPhotoIDs(userID primitive.ObjectID, ids []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	if len(ids) == 0 {
+		return []primitive.ObjectID{}, nil
+	}
+
+	cursor, err := database.GetPhotoCollection().Find(context.Background(),
+		bson.M{"_id": bson.M{"$in": ids}, "user_id": userID, "deleted_at": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var found []models.Photo
+	if err := cursor.All(context.Background(), &found); err != nil {
+		return nil, err
+	}
+
+	valid := make(map[primitive.ObjectID]bool, len(found))
+	for _, p := range found {
+		valid[p.ID] = true
+	}
+
+	result := make([]primitive.ObjectID, 0, len(found))
+	for _, id := range ids {
+		if valid[id] {
+			result = append(result, id)
+			delete(valid, id)
+		}
+	}
+	return result, nil
+}
+
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+func samePhotoSet(a, b []primitive.ObjectID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[primitive.ObjectID]int, len(a))
+	for _, id := range a {
+		counts[id]++
+	}
+	for _, id := range b {
+		if counts[id] != 1 {
+			return false
+		}
+		counts[id]--
+	}
+	return true
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ListSharedAlbums lists the albums other accounts have shared with the
+// caller.
+func ListSharedAlbums(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	cursor, err := database.GetAlbumCollection().Find(context.Background(),
+		bson.M{"members.user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch albums"})
+		return
+	}
+
+	albums := []models.Album{}
+	if err := cursor.All(context.Background(), &albums); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode albums"})
+		return
+	}
+
+	ownerIDs := make([]primitive.ObjectID, len(albums))
+	for i, a := range albums {
+		ownerIDs[i] = a.UserID
+	}
+	owners, err := userEmails(ownerIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch album owners"})
+		return
+	}
+
+	for i := range albums {
+		fillAlbum(&albums[i], userID.Hex())
+		albums[i].OwnerEmail = owners[albums[i].UserID]
+	}
+
+	c.JSON(http.StatusOK, albums)
+}
+
+// AddAlbumMember shares the album with another account by email. Adding
+// someone who is already a member changes their role.
+func AddAlbumMember(c *gin.Context) {
+	album, ok := findAlbum(c, models.AlbumRoleOwner)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if !validMemberRole(body.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be viewer, contributor or editor"})
+		return
+	}
+
+	var user models.User
+	err := database.GetUserCollection().FindOne(context.Background(), bson.M{"email": strings.TrimSpace(body.Email)}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch user"})
+		return
+	}
+	if user.ID == album.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you already own this album"})
+		return
+	}
+
+	collection := database.GetAlbumCollection()
+	now := time.Now().Unix()
+
+	res, err := collection.UpdateOne(context.Background(),
+		bson.M{"_id": album.ID, "members.user_id": user.ID},
+		bson.M{"$set": bson.M{"members.$.role": body.Role, "updated_at": now}},
+	)
+	if err == nil && res.MatchedCount == 0 {
+		member := models.AlbumMember{UserID: user.ID, Email: user.Email, Role: body.Role, AddedAt: now}
+		_, err = collection.UpdateOne(context.Background(),
+			bson.M{"_id": album.ID, "members.user_id": bson.M{"$ne": user.ID}},
+			bson.M{"$push": bson.M{"members": member}, "$set": bson.M{"updated_at": now}},
+		)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to share album"})
+		return
+	}
+
+	respondAlbumMembers(c, album.ID)
+}
+
+// UpdateAlbumMember changes a member's role.
+func UpdateAlbumMember(c *gin.Context) {
+	album, ok := findAlbum(c, models.AlbumRoleOwner)
+	if !ok {
+		return
+	}
+
+	memberID, err := primitive.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if !validMemberRole(body.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be viewer, contributor or editor"})
+		return
+	}
+
+	res, err := database.GetAlbumCollection().UpdateOne(context.Background(),
+		bson.M{"_id": album.ID, "members.user_id": memberID},
+		bson.M{"$set": bson.M{"members.$.role": body.Role, "updated_at": time.Now().Unix()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update member"})
+		return
+	}
+	if res.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+		return
+	}
+
+	respondAlbumMembers(c, album.ID)
+}
+
+// RemoveAlbumMember stops sharing the album with a member. Members can also
+// remove themselves to leave the album. Photos they added stay in it.
+func RemoveAlbumMember(c *gin.Context) {
+	album, ok := findAlbum(c, models.AlbumRoleViewer)
+	if !ok {
+		return
+	}
+
+	memberID, err := primitive.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+	if userID != album.UserID && userID != memberID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed for your role in this album"})
+		return
+	}
+
+	res, err := database.GetAlbumCollection().UpdateOne(context.Background(),
+		bson.M{"_id": album.ID, "members.user_id": memberID},
+		bson.M{"$pull": bson.M{"members": bson.M{"user_id": memberID}}, "$set": bson.M{"updated_at": time.Now().Unix()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove member"})
+		return
+	}
+	if res.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func respondAlbumMembers(c *gin.Context, albumID primitive.ObjectID) {
+	var album models.Album
+	err := database.GetAlbumCollection().FindOne(context.Background(), bson.M{"_id": albumID},
+		options.FindOne().SetProjection(bson.M{"members": 1}),
+	).Decode(&album)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch members"})
+		return
+	}
+	if album.Members == nil {
+		album.Members = []models.AlbumMember{}
+	}
+	c.JSON(http.StatusOK, gin.H{"members": album.Members})
+}
+
+func validMemberRole(role string) bool {
+	return role == models.AlbumRoleViewer || role == models.AlbumRoleContributor || role == models.AlbumRoleEditor
+}
+
+// userEmails maps user IDs to their email addresses.
+func userEmails(ids []primitive.ObjectID) (map[primitive.ObjectID]string, error) {
+	emails := make(map[primitive.ObjectID]string, len(ids))
+	if len(ids) == 0 {
+		return emails, nil
+	}
+
+	cursor, err := database.GetUserCollection().Find(context.Background(),
+		bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"email": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(context.Background(), &users); err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		emails[u.ID] = u.Email
+	}
+	return emails, nil
+}
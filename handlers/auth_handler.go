@@ -2,26 +2,44 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"net/mail"
 	"photo-storage-backend/database"
+	"photo-storage-backend/lockout"
 	"photo-storage-backend/models"
+	"photo-storage-backend/repository"
 	"photo-storage-backend/utils"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
+type registerInput struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type loginInput struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
 func Register(c *gin.Context) {
-	var input models.User
+	var input registerInput
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
 		return
 	}
 
-	if len(input.Password) < 6 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "password must be at least 6 characters"})
+	if err := validatePassword(input.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -39,41 +57,152 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	hashed, _ := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
-	input.Password = string(hashed)
+	hashed, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register"})
+		return
+	}
+	user := models.User{
+		ID:       primitive.NewObjectID(),
+		Name:     input.Name,
+		Email:    input.Email,
+		Password: string(hashed),
+	}
 
-	_, err = collection.InsertOne(context.Background(), input)
+	_, err = collection.InsertOne(context.Background(), user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register"})
 		return
 	}
 
+	if err := sendVerificationEmail(c.Request.Context(), &user); err != nil {
+		log.Printf("Failed to send verification email: %v", err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "registered"})
 }
 
+// errInvalidCredentials is the only answer to a bad login, so callers
+// can't tell which accounts exist.
+const errInvalidCredentials = "invalid email or password"
+
+// dummyHash is compared against when there is no account, so a login for
+// an unknown email takes as long as one with a wrong password.
+// bcrypt only looks at the first 72 bytes and refuses anything longer
+const maxPasswordBytes = 72
+
+// validatePassword returns why a new password can't be used, in words fit
+// for the response.
+func validatePassword(password string) error {
+	if len(password) < 6 {
+		return errors.New("password must be at least 6 characters")
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordBytes)
+	}
+	return nil
+}
+
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
 func Login(c *gin.Context) {
-	var input models.User
+	var input loginInput
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
 		return
 	}
 
+	ctx := c.Request.Context()
+	limiter := lockout.Get()
+	ip := c.ClientIP()
+	audit := models.AuthAuditEvent{Email: input.Email, IP: ip, UserAgent: c.Request.UserAgent()}
+
+	wait, err := limiter.Attempt(ctx, input.Email, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log in"})
+		return
+	}
+	if wait > 0 {
+		audit.Event = models.AuthEventLoginLocked
+		repository.RecordAuthEvent(audit)
+
+		tooManyAttempts(c, wait)
+		return
+	}
+
 	collection := database.GetUserCollection()
 	var user models.User
-	err := collection.FindOne(context.Background(), bson.M{"email": input.Email}).Decode(&user)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+	err = collection.FindOne(context.Background(), bson.M{"email": input.Email}).Decode(&user)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log in"})
+		return
+	}
+
+	hash := []byte(user.Password)
+	switch {
+	case err != nil:
+		audit.Reason = "unknown_email"
+		hash = dummyHash
+	case user.Password == "":
+		audit.Reason = "no_password" // signs in with an external provider
+		audit.UserID = &user.ID
+		hash = dummyHash
+	default:
+		audit.UserID = &user.ID
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(input.Password)) != nil && audit.Reason == "" {
+		audit.Reason = "wrong_password"
+	}
+	if audit.Reason != "" {
+		// Attempt already counted the failure
+		audit.Event = models.AuthEventLoginFailed
+		repository.RecordAuthEvent(audit)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": errInvalidCredentials})
+		return
+	}
+
+	if err := limiter.Succeed(ctx, input.Email, ip); err != nil {
+		log.Printf("Failed to reset failed logins: %v", err)
+	}
+
+	if user.Disabled() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+		return
+	}
+	if user.PasswordResetRequired {
+		c.JSON(http.StatusForbidden, gin.H{"error": "password reset required, check your email", "password_reset_required": true})
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "wrong password"})
+	// With two-factor auth on, the password only gets a token for the
+	// second step (LoginMFA)
+	if user.MFAEnabled() {
+		mfaToken, err := utils.GenerateMFAToken(user.ID.Hex())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start session"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   int(utils.MFATokenTTL.Seconds()),
+		})
 		return
 	}
 
-	token, _ := utils.GenerateToken(user.ID.Hex())
-	c.JSON(http.StatusOK, gin.H{
-		"token": token,
-		"name":  user.Name,
-	})
+	tokens, err := startSession(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start session"})
+		return
+	}
+	tokens["name"] = user.Name
+	c.JSON(http.StatusOK, tokens)
+}
+
+// JWKS publishes the public token verification keys so other services can
+// check tokens themselves. Empty when tokens are HMAC signed.
+func JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, utils.JWKS())
 }
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"photo-storage-backend/cleanup"
+	"photo-storage-backend/database"
+	"photo-storage-backend/metadata"
+	"photo-storage-backend/models"
+	"photo-storage-backend/repository"
+	"photo-storage-backend/storage"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// photoBatch turns uploaded files into photo documents for one user and one
+// batch. Content is stored once per SHA-256 digest, and a user never gets two
+// photos with the same content.
+type photoBatch struct {
+	userID  primitive.ObjectID
+	batchID primitive.ObjectID
+	store   storage.BlobStore
+
+	// digest -> photo ID, catches the same file twice in one batch
+	seen map[string]primitive.ObjectID
+
+	// quota, if set, is checked for each new file before it is stored, and
+	// the file is counted against it
+	quota *repository.Quota
+}
+
+var (
+	errQuotaExceeded  = errors.New("storage quota exceeded")
+	errInvalidDigest  = errors.New("sha256 must be 64 hex characters")
+	errDigestMismatch = errors.New("file does not match its sha256")
+)
+
+type duplicatePhoto struct {
+	Name    string             `json:"name"`
+	PhotoID primitive.ObjectID `json:"photo_id"`
+	Status  string             `json:"status"`
+}
+
+func newPhotoBatch(userID, batchID primitive.ObjectID) *photoBatch {
+	return &photoBatch{
+		userID:  userID,
+		batchID: batchID,
+		store:   storage.GetStore(),
+		seen:    make(map[string]primitive.ObjectID),
+	}
+}
+
+// add hashes, validates and stores r. It returns the photo to insert, or,
+// if the user already has this content, the existing photo as a duplicate
+// and stores nothing. Files that aren't images we accept are rejected with
+// one of the validation errors, as are files that don't match the SHA-256
+// the client sent, if it sent one.
+func (b *photoBatch) add(ctx context.Context, name, sha256 string, r io.Reader) (*models.Photo, *duplicatePhoto, error) {
+	name = sanitizeFilename(name)
+
+	var ok bool
+	if sha256 != "" {
+		if sha256, ok = parseDigest(sha256); !ok {
+			return nil, nil, errInvalidDigest
+		}
+	}
+
+	staged, err := storage.Stage(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer staged.Close()
+
+	if sha256 != "" && staged.Digest != sha256 {
+		return nil, nil, errDigestMismatch
+	}
+
+	if id, ok := b.seen[staged.Digest]; ok {
+		return nil, newDuplicate(name, id), nil
+	}
+
+	dup, err := findDuplicate(ctx, b.userID, name, staged.Digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dup != nil {
+		b.seen[staged.Digest] = dup.PhotoID
+		return nil, dup, nil
+	}
+
+	contentType, err := validateImage(staged.File)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if b.quota != nil {
+		ok, err := repository.ReserveUsage(ctx, b.userID, *b.quota, staged.Size, 1)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			return nil, nil, errQuotaExceeded
+		}
+	}
+
+	// Take the reference before writing. A release that already started
+	// deleting the object finishes before AcquireBlob returns, so an object
+	// PutStaged finds is one that stays.
+	key := storage.BlobKey(staged.Digest)
+	if err := repository.AcquireBlob(ctx, staged.Digest, key, staged.Size, contentType); err != nil {
+		b.release(staged.Size)
+		return nil, nil, err
+	}
+	if _, err := storage.PutStaged(ctx, b.store, staged, contentType); err != nil {
+		cleanup.ReleaseBlob(ctx, staged.Digest, key)
+		b.release(staged.Size)
+		return nil, nil, err
+	}
+
+	photo := &models.Photo{
+		ID:          primitive.NewObjectID(),
+		Name:        name,
+		Path:        key,
+		UploadAt:    time.Now().Unix(),
+		UserID:      b.userID,
+		Embedded:    false,
+		BatchID:     b.batchID,
+		Hash:        staged.Digest,
+		Size:        staged.Size,
+		ContentType: contentType,
+	}
+
+	photo.TakenAt = photo.UploadAt
+	if _, err := staged.File.Seek(0, io.SeekStart); err == nil {
+		photo.Metadata = metadata.Extract(staged.File)
+	}
+	if photo.Metadata != nil && photo.Metadata.TakenAt != 0 {
+		photo.TakenAt = photo.Metadata.TakenAt
+	}
+	b.seen[staged.Digest] = photo.ID
+	return photo, nil, nil
+}
+
+// insert saves the photos returned by add. Photos that lost a race against a
+// concurrent upload of the same content come back as duplicates, anything
+// else that could not be saved comes back in failed.
+func (b *photoBatch) insert(ctx context.Context, photos []models.Photo) (inserted []models.Photo, duplicates []duplicatePhoto, failed []models.Photo) {
+	if len(photos) == 0 {
+		return nil, nil, nil
+	}
+
+	docs := make([]interface{}, len(photos))
+	for i, p := range photos {
+		docs[i] = p
+	}
+
+	collection := database.GetPhotoCollection()
+	_, err := collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err == nil {
+		return photos, nil, nil
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) {
+		log.Printf("Failed to insert photos: %v", err)
+		for _, p := range photos {
+			cleanup.ReleaseBlob(ctx, p.Hash, p.Path)
+			b.release(p.Size)
+		}
+		return nil, nil, photos
+	}
+
+	writeErrs := make(map[int]mongo.WriteError, len(bulkErr.WriteErrors))
+	for _, we := range bulkErr.WriteErrors {
+		writeErrs[we.Index] = we.WriteError
+	}
+
+	for i, p := range photos {
+		we, ok := writeErrs[i]
+		if !ok {
+			inserted = append(inserted, p)
+			continue
+		}
+
+		cleanup.ReleaseBlob(ctx, p.Hash, p.Path)
+		b.release(p.Size)
+		if mongo.IsDuplicateKeyError(we) {
+			if dup, err := findDuplicate(ctx, b.userID, p.Name, p.Hash); err == nil && dup != nil {
+				duplicates = append(duplicates, *dup)
+				continue
+			}
+		}
+		log.Printf("Failed to insert photo %s: %v", p.Name, we)
+		failed = append(failed, p)
+	}
+	return inserted, duplicates, failed
+}
+
+// release gives back the quota of a file that was reserved but not saved.
+func (b *photoBatch) release(size int64) {
+	if b.quota != nil {
+		releaseQuota(b.userID, size, 1)
+	}
+}
+
+// parseDigest checks that s is a hex SHA-256 digest and returns it in the
+// lowercase form photos store.
+func parseDigest(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) != 64 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return "", false
+	}
+	return s, true
+}
+
+func newDuplicate(name string, id primitive.ObjectID) *duplicatePhoto {
+	return &duplicatePhoto{Name: name, PhotoID: id, Status: "already exists"}
+}
+
+// findDuplicate looks for the user's photo with this content. Uploading
+// something that is sitting in the trash brings the trashed photo back
+// instead of creating a new one.
+func findDuplicate(ctx context.Context, userID primitive.ObjectID, name, digest string) (*duplicatePhoto, error) {
+	collection := database.GetPhotoCollection()
+
+	var existing models.Photo
+	err := collection.FindOne(ctx,
+		bson.M{"user_id": userID, "hash": digest},
+		options.FindOne().SetProjection(bson.M{"_id": 1, "deleted_at": 1}),
+	).Decode(&existing)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if existing.DeletedAt != 0 {
+		if _, err := collection.UpdateByID(ctx, existing.ID, bson.M{"$unset": bson.M{"deleted_at": ""}}); err != nil {
+			return nil, err
+		}
+		return &duplicatePhoto{Name: name, PhotoID: existing.ID, Status: "restored from trash"}, nil
+	}
+	return newDuplicate(name, existing.ID), nil
+}
@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"photo-storage-backend/database"
+	"photo-storage-backend/lockout"
+	"photo-storage-backend/models"
+	"photo-storage-backend/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const recoveryCodeCount = 10
+
+// EnrollMFA starts TOTP enrollment. The secret only takes effect once a
+// code from it is confirmed with ConfirmMFA.
+func EnrollMFA(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if user.MFAEnabled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor auth is already enabled"})
+		return
+	}
+
+	secret, err := utils.NewTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start enrollment"})
+		return
+	}
+
+	_, err = database.GetUserCollection().UpdateByID(context.Background(), user.ID,
+		bson.M{"$set": bson.M{"mfa.pending_secret": secret}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start enrollment"})
+		return
+	}
+
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		issuer = "Photo Storage"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": utils.TOTPURI(issuer, user.Email, secret),
+	})
+}
+
+// ConfirmMFA turns two-factor auth on with a code from the enrolled secret
+// and returns the recovery codes. They are shown this one time only.
+func ConfirmMFA(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if user.MFAEnabled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor auth is already enabled"})
+		return
+	}
+	if user.MFA == nil || user.MFA.PendingSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start enrollment first"})
+		return
+	}
+
+	step, valid := utils.ValidateTOTP(user.MFA.PendingSecret, body.Code, time.Now())
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+		return
+	}
+
+	codes, hashed, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable two-factor auth"})
+		return
+	}
+
+	mfa := models.UserMFA{
+		Secret:        user.MFA.PendingSecret,
+		RecoveryCodes: hashed,
+		LastStep:      step,
+		EnabledAt:     time.Now().Unix(),
+	}
+	_, err = database.GetUserCollection().UpdateOne(context.Background(),
+		bson.M{"_id": user.ID, "mfa.pending_secret": user.MFA.PendingSecret},
+		bson.M{"$set": bson.M{"mfa": mfa}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable two-factor auth"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "recovery_codes": codes})
+}
+
+// DisableMFA turns two-factor auth off. It takes the password (for accounts
+// that have one) and a current code or recovery code.
+func DisableMFA(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if !user.MFAEnabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor auth is not enabled"})
+		return
+	}
+	if !checkPassword(user, body.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "wrong password"})
+		return
+	}
+
+	if !checkMFACode(c, user, body.Code) {
+		return
+	}
+
+	if _, err := database.GetUserCollection().UpdateByID(context.Background(), user.ID, bson.M{"$unset": bson.M{"mfa": ""}}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable two-factor auth"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes with new ones.
+func RegenerateRecoveryCodes(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if !user.MFAEnabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor auth is not enabled"})
+		return
+	}
+
+	if !checkMFACode(c, user, body.Code) {
+		return
+	}
+
+	codes, hashed, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create recovery codes"})
+		return
+	}
+	if _, err := database.GetUserCollection().UpdateByID(context.Background(), user.ID,
+		bson.M{"$set": bson.M{"mfa.recovery_codes": hashed}}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "recovery_codes": codes})
+}
+
+// LoginMFA is the second login step: it trades the mfa_token from Login and
+// a code for a session.
+func LoginMFA(c *gin.Context) {
+	var body struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.MFAToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mfa_token and code are required"})
+		return
+	}
+
+	userIDStr, tokenID, err := utils.ParseMFAToken(body.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa_token"})
+		return
+	}
+	userID, _ := primitive.ObjectIDFromHex(userIDStr)
+
+	limiter := lockout.Get()
+	wait, err := limiter.AttemptMFA(c.Request.Context(), userIDStr, tokenID, utils.MFATokenTTL)
+	if errors.Is(err, lockout.ErrTokenUsedUp) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa_token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check code"})
+		return
+	}
+	if wait > 0 {
+		tooManyAttempts(c, wait)
+		return
+	}
+
+	var user models.User
+	if err := database.GetUserCollection().FindOne(context.Background(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa_token"})
+		return
+	}
+	if user.Disabled() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+		return
+	}
+
+	valid, err := verifyMFACode(c.Request.Context(), &user, body.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check code"})
+		return
+	}
+	if !valid {
+		// AttemptMFA already counted the failure
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return
+	}
+	if err := limiter.SucceedMFA(c.Request.Context(), userIDStr); err != nil {
+		log.Printf("Failed to reset failed codes: %v", err)
+	}
+
+	tokens, err := startSession(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start session"})
+		return
+	}
+	tokens["name"] = user.Name
+	c.JSON(http.StatusOK, tokens)
+}
+
+// checkMFACode verifies a code from a signed in user, with the same per
+// user limit as LoginMFA. When it returns false the response has been
+// written.
+func checkMFACode(c *gin.Context, user *models.User, code string) bool {
+	limiter := lockout.Get()
+	wait, err := limiter.AttemptMFACode(c.Request.Context(), user.ID.Hex())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check code"})
+		return false
+	}
+	if wait > 0 {
+		tooManyAttempts(c, wait)
+		return false
+	}
+
+	valid, err := verifyMFACode(c.Request.Context(), user, code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check code"})
+		return false
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return false
+	}
+	if err := limiter.SucceedMFA(c.Request.Context(), user.ID.Hex()); err != nil {
+		log.Printf("Failed to reset failed codes: %v", err)
+	}
+	return true
+}
+
+// tooManyAttempts answers a request the lockout limiter turned away.
+func tooManyAttempts(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed attempts, try again later", "retry_after": seconds})
+}
+
+// verifyMFACode accepts a TOTP code or an unused recovery code. Each TOTP
+// time step and each recovery code is only accepted once.
+func verifyMFACode(ctx context.Context, user *models.User, code string) (bool, error) {
+	if !user.MFAEnabled() {
+		return false, nil
+	}
+	collection := database.GetUserCollection()
+
+	code = strings.TrimSpace(code)
+	if step, ok := utils.ValidateTOTP(user.MFA.Secret, code, time.Now()); ok {
+		res, err := collection.UpdateOne(ctx,
+			bson.M{"_id": user.ID, "mfa.last_step": bson.M{"$lt": step}},
+			bson.M{"$set": bson.M{"mfa.last_step": step}},
+		)
+		if err != nil {
+			return false, err
+		}
+		return res.ModifiedCount == 1, nil
+	}
+
+	normalized := strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	if normalized == "" {
+		return false, nil
+	}
+	hash := utils.HashToken(normalized)
+	res, err := collection.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "mfa.recovery_codes": hash},
+		bson.M{"$pull": bson.M{"mfa.recovery_codes": hash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// newRecoveryCodes returns codes formatted for the user, like
+// "k3f9a-2mxq7", and their hashes for storage.
+func newRecoveryCodes() (codes, hashed []string, err error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(enc.EncodeToString(b))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashed = append(hashed, utils.HashToken(raw))
+	}
+	return codes, hashed, nil
+}
+
+// currentUser loads the caller's user document, writing the error response
+// if it can't.
+func currentUser(c *gin.Context) (*models.User, bool) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	var user models.User
+	if err := database.GetUserCollection().FindOne(context.Background(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return nil, false
+	}
+	return &user, true
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+	"photo-storage-backend/oidc"
+	"photo-storage-backend/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+var errOIDCEmailTaken = errors.New("an account with this email already exists, sign in with your password")
+
+// ListOIDCProviders lists the providers users can sign in with.
+func ListOIDCProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": oidc.Names()})
+}
+
+// OIDCLogin starts a sign-in with an external provider. The state, nonce
+// and PKCE verifier go into a signed cookie until the provider sends the
+// browser back to OIDCCallback.
+func OIDCLogin(c *gin.Context) {
+	provider, ok := oidc.Get(c.Param("provider"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider"})
+		return
+	}
+
+	state, err := utils.RandomToken(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start sign-in"})
+		return
+	}
+	nonce, err := utils.RandomToken(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start sign-in"})
+		return
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start sign-in"})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), state, nonce, challenge)
+	if err != nil {
+		log.Printf("OIDC %s: %v", provider.Name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider unavailable"})
+		return
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(oidcStateTTL).Unix(), 10)
+	value := utils.SignValue(strings.Join([]string{provider.Name, state, nonce, verifier, expires}, "|"))
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, int(oidcStateTTL.Seconds()), "/api/auth/oidc", "", secureRequest(c), true)
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback finishes the sign-in and sends the browser back to the
+// frontend with our tokens in the URL fragment, which never reaches a
+// server log.
+func OIDCCallback(c *gin.Context) {
+	provider, ok := oidc.Get(c.Param("provider"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider"})
+		return
+	}
+
+	cookie, _ := c.Cookie(oidcStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, "/api/auth/oidc", "", secureRequest(c), true)
+
+	if e := c.Query("error"); e != "" {
+		oidcRedirect(c, url.Values{"error": {e}})
+		return
+	}
+
+	value, ok := utils.VerifyValue(cookie)
+	parts := strings.Split(value, "|")
+	if !ok || len(parts) != 5 || parts[0] != provider.Name || parts[1] != c.Query("state") || c.Query("state") == "" {
+		oidcRedirect(c, url.Values{"error": {"invalid_state"}})
+		return
+	}
+	nonce, verifier := parts[2], parts[3]
+	if expires, err := strconv.ParseInt(parts[4], 10, 64); err != nil || time.Now().Unix() > expires {
+		oidcRedirect(c, url.Values{"error": {"invalid_state"}})
+		return
+	}
+
+	identity, err := provider.Exchange(c.Request.Context(), c.Query("code"), verifier, nonce)
+	if err != nil {
+		log.Printf("OIDC %s: %v", provider.Name, err)
+		oidcRedirect(c, url.Values{"error": {"login_failed"}})
+		return
+	}
+
+	user, err := linkOIDCUser(c.Request.Context(), provider.Name, identity)
+	if errors.Is(err, errOIDCEmailTaken) {
+		oidcRedirect(c, url.Values{"error": {"email_taken"}})
+		return
+	}
+	if err != nil {
+		log.Printf("OIDC %s: failed to link user: %v", provider.Name, err)
+		oidcRedirect(c, url.Values{"error": {"login_failed"}})
+		return
+	}
+
+	if user.Disabled() {
+		oidcRedirect(c, url.Values{"error": {"account_disabled"}})
+		return
+	}
+
+	// The provider replaces the password, not our second factor
+	if user.MFAEnabled() {
+		mfaToken, err := utils.GenerateMFAToken(user.ID.Hex())
+		if err != nil {
+			oidcRedirect(c, url.Values{"error": {"login_failed"}})
+			return
+		}
+		oidcRedirect(c, url.Values{"mfa_required": {"true"}, "mfa_token": {mfaToken}})
+		return
+	}
+
+	tokens, err := startSession(c, user.ID)
+	if err != nil {
+		oidcRedirect(c, url.Values{"error": {"login_failed"}})
+		return
+	}
+
+	fragment := url.Values{}
+	fragment.Set("token", tokens["token"].(string))
+	fragment.Set("refresh_token", tokens["refresh_token"].(string))
+	fragment.Set("expires_in", strconv.Itoa(tokens["expires_in"].(int)))
+	fragment.Set("name", user.Name)
+	oidcRedirect(c, fragment)
+}
+
+func oidcRedirect(c *gin.Context, fragment url.Values) {
+	c.Redirect(http.StatusFound, frontendURL("/auth/callback")+"#"+fragment.Encode())
+}
+
+// linkOIDCUser finds the account for an external identity. Identities are
+// matched by provider and subject, then by email to link an existing
+// account, if both the provider and the account verified it. Otherwise a
+// new account without a password is created.
+func linkOIDCUser(ctx context.Context, provider string, id *oidc.Identity) (*models.User, error) {
+	collection := database.GetUserCollection()
+	hasIdentity := bson.M{"$elemMatch": bson.M{"provider": provider, "subject": id.Subject}}
+	byIdentity := bson.M{"identities": hasIdentity}
+
+	var user models.User
+	err := collection.FindOne(ctx, byIdentity).Decode(&user)
+	if err == nil {
+		return &user, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	identity := models.UserIdentity{
+		Provider: provider,
+		Subject:  id.Subject,
+		Email:    id.Email,
+		LinkedAt: time.Now().Unix(),
+	}
+
+	var verified *models.User
+	var others int64
+	if id.Email != "" {
+		err = collection.FindOne(ctx, bson.M{"email": id.Email, "email_verified": true}).Decode(&user)
+		if err == nil {
+			verified = &user
+		} else if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+
+		if verified == nil {
+			others, err = collection.CountDocuments(ctx, bson.M{"email": id.Email})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch matchOIDCEmail(id, verified != nil, others) {
+	case oidcEmailTaken:
+		return nil, errOIDCEmailTaken
+	case oidcLinkAccount:
+		_, err = collection.UpdateOne(ctx,
+			bson.M{"_id": verified.ID, "identities": bson.M{"$not": hasIdentity}},
+			bson.M{"$push": bson.M{"identities": identity}})
+		if err == nil {
+			return verified, nil
+		}
+	default:
+		name := id.Name
+		if name == "" {
+			name, _, _ = strings.Cut(id.Email, "@")
+		}
+		user = models.User{
+			ID:            primitive.NewObjectID(),
+			Name:          name,
+			Email:         id.Email,
+			EmailVerified: id.EmailVerified,
+			Identities:    []models.UserIdentity{identity},
+		}
+		_, err = collection.InsertOne(ctx, user)
+		if err == nil {
+			return &user, nil
+		}
+	}
+
+	// A callback for the same identity running at the same time got there
+	// first, sign in to the account it linked
+	if mongo.IsDuplicateKeyError(err) {
+		if err := collection.FindOne(ctx, byIdentity).Decode(&user); err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+	return nil, err
+}
+
+type oidcEmailMatch int
+
+const (
+	oidcNewAccount oidcEmailMatch = iota
+	oidcLinkAccount
+	oidcEmailTaken
+)
+
+// matchOIDCEmail decides where an identity without an account of its own
+// goes, given whether an account verified its email and how many other
+// accounts use it. Only link when both sides proved they own the address:
+// anyone can register someone else's email with a password, and linking
+// that account would hand the owner's sign-ins to whoever registered it.
+func matchOIDCEmail(id *oidc.Identity, verifiedAccount bool, others int64) oidcEmailMatch {
+	switch {
+	case id.Email == "":
+		return oidcNewAccount
+	case verifiedAccount && id.EmailVerified:
+		return oidcLinkAccount
+	case verifiedAccount || others > 0:
+		return oidcEmailTaken
+	}
+	return oidcNewAccount
+}
+
+func secureRequest(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"photo-storage-backend/oidc"
+	"testing"
+)
+
+func TestMatchOIDCEmail(t *testing.T) {
+	tests := []struct {
+		name            string
+		email           string
+		emailVerified   bool
+		verifiedAccount bool
+		others          int64
+		want            oidcEmailMatch
+	}{
+		{name: "no email", want: oidcNewAccount},
+		{name: "new email", email: "a@example.com", emailVerified: true, want: oidcNewAccount},
+		{name: "new unverified email", email: "a@example.com", want: oidcNewAccount},
+		{name: "both verified", email: "a@example.com", emailVerified: true, verifiedAccount: true, want: oidcLinkAccount},
+		{name: "provider didn't verify", email: "a@example.com", verifiedAccount: true, want: oidcEmailTaken},
+		{name: "account didn't verify", email: "a@example.com", emailVerified: true, others: 1, want: oidcEmailTaken},
+		{name: "neither verified", email: "a@example.com", others: 1, want: oidcEmailTaken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := &oidc.Identity{Subject: "s", Email: tt.email, EmailVerified: tt.emailVerified}
+			if got := matchOIDCEmail(id, tt.verifiedAccount, tt.others); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
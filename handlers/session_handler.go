@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+	"photo-storage-backend/repository"
+	"photo-storage-backend/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// startSession creates a session for a successful login and returns the
+// token pair for the response.
+func startSession(c *gin.Context, userID primitive.ObjectID) (gin.H, error) {
+	session, refreshToken, err := repository.CreateSession(c.Request.Context(), userID, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		return nil, err
+	}
+	return tokenResponse(userID.Hex(), session.ID.Hex(), refreshToken)
+}
+
+func tokenResponse(userID, sessionID, refreshToken string) (gin.H, error) {
+	token, err := utils.GenerateToken(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(utils.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// RefreshToken trades a refresh token for a new access token and a new
+// refresh token. The old refresh token stops working.
+func RefreshToken(c *gin.Context) {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token is required"})
+		return
+	}
+
+	session, refreshToken, err := repository.RotateRefreshToken(c.Request.Context(), body.RefreshToken, c.Request.UserAgent(), c.ClientIP())
+	if errors.Is(err, repository.ErrRefreshTokenReused) {
+		log.Printf("Refresh token reused, session revoked")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		return
+	}
+	if errors.Is(err, repository.ErrInvalidRefreshToken) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh token"})
+		return
+	}
+
+	tokens, err := tokenResponse(session.UserID.Hex(), session.ID.Hex(), refreshToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh token"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout revokes the caller's session, along with its refresh token.
+func Logout(c *gin.Context) {
+	sessionIDStr := c.GetString("sessionID")
+	sessionID, err := primitive.ObjectIDFromHex(sessionIDStr)
+	if err != nil {
+		// Token from before sessions existed, nothing to revoke
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		return
+	}
+
+	if err := repository.RevokeSession(c.Request.Context(), sessionID, "logout"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ListSessions lists the caller's active sessions, most recently used
+// first. The one making the request is marked current.
+func ListSessions(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	cursor, err := database.GetSessionCollection().Find(context.Background(),
+		bson.M{
+			"user_id":    userID,
+			"revoked_at": bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": time.Now().Unix()},
+		},
+		options.Find().SetSort(bson.D{{Key: "last_seen_at", Value: -1}}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch sessions"})
+		return
+	}
+
+	sessions := []models.Session{}
+	if err := cursor.All(context.Background(), &sessions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode sessions"})
+		return
+	}
+
+	current := c.GetString("sessionID")
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID.Hex() == current
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession logs one of the caller's sessions out.
+func RevokeSession(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	sessionID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session ID"})
+		return
+	}
+
+	count, err := database.GetSessionCollection().CountDocuments(context.Background(),
+		bson.M{"_id": sessionID, "user_id": userID, "revoked_at": bson.M{"$exists": false}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch session"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	if err := repository.RevokeSession(c.Request.Context(), sessionID, "revoked by user"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// RevokeOtherSessions logs the caller out everywhere except here.
+func RevokeOtherSessions(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	var except *primitive.ObjectID
+	if sessionID, err := primitive.ObjectIDFromHex(c.GetString("sessionID")); err == nil {
+		except = &sessionID
+	}
+
+	revoked, err := repository.RevokeUserSessions(c.Request.Context(), userID, except, "revoked by user")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "revoked_count": revoked})
+}
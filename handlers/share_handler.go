@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"photo-storage-backend/database"
+	"photo-storage-backend/lockout"
+	"photo-storage-backend/models"
+	"photo-storage-backend/renditions"
+	"photo-storage-backend/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sharedPhoto is what anonymous visitors of a share link get to see. It
+// leaves out the hash and EXIF (GPS in particular) on purpose.
+type sharedPhoto struct {
+	ID           primitive.ObjectID `json:"id"`
+	Name         string             `json:"name"`
+	TakenAt      int64              `json:"taken_at"`
+	URL          string             `json:"url"`
+	ThumbnailURL string             `json:"thumbnail_url"`
+}
+
+func CreateShare(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	var body struct {
+		PhotoID       string `json:"photo_id"`
+		AlbumID       string `json:"album_id"`
+		ExpiresIn     int64  `json:"expires_in"` // seconds, 0 means never
+		Password      string `json:"password"`
+		AllowDownload bool   `json:"allow_download"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if (body.PhotoID == "") == (body.AlbumID == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provide either photo_id or album_id"})
+		return
+	}
+	if body.ExpiresIn < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in must not be negative"})
+		return
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create share"})
+		return
+	}
+
+	now := time.Now()
+	share := models.Share{
+		ID:            primitive.NewObjectID(),
+		UserID:        userID,
+		Token:         token,
+		AllowDownload: body.AllowDownload,
+		CreatedAt:     now.Unix(),
+	}
+	if body.ExpiresIn > 0 {
+		share.ExpiresAt = now.Add(time.Duration(body.ExpiresIn) * time.Second).Unix()
+	}
+
+	if body.PhotoID != "" {
+		photoID, err := primitive.ObjectIDFromHex(body.PhotoID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid photo ID"})
+			return
+		}
+		owned, err := ownedPhotoIDs(userID, []primitive.ObjectID{photoID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check photo"})
+			return
+		}
+		if len(owned) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "photo not found"})
+			return
+		}
+		share.Kind = models.ShareKindPhoto
+		share.PhotoID = &photoID
+	} else {
+		album, ok := loadAlbum(c, body.AlbumID, userID, models.AlbumRoleOwner)
+		if !ok {
+			return
+		}
+		share.Kind = models.ShareKindAlbum
+		share.AlbumID = &album.ID
+	}
+
+	if len(body.Password) > maxPasswordBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("password must be at most %d bytes", maxPasswordBytes)})
+		return
+	}
+	if body.Password != "" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create share"})
+			return
+		}
+		share.PasswordHash = string(hashed)
+	}
+
+	if _, err := database.GetShareCollection().InsertOne(context.Background(), share); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create share"})
+		return
+	}
+
+	fillShare(&share)
+	c.JSON(http.StatusOK, share)
+}
+
+// ListShares lists the caller's links that have not been revoked.
+func ListShares(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	cursor, err := database.GetShareCollection().Find(context.Background(),
+		bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch shares"})
+		return
+	}
+
+	shares := []models.Share{}
+	if err := cursor.All(context.Background(), &shares); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode shares"})
+		return
+	}
+	for i := range shares {
+		fillShare(&shares[i])
+	}
+
+	c.JSON(http.StatusOK, shares)
+}
+
+func RevokeShare(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	shareID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share ID"})
+		return
+	}
+
+	res, err := database.GetShareCollection().UpdateOne(context.Background(),
+		bson.M{"_id": shareID, "user_id": userID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().Unix()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke share"})
+		return
+	}
+	if res.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "share not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func fillShare(share *models.Share) {
+	share.HasPassword = share.PasswordHash != ""
+	share.URL = "/s/" + share.Token
+}
+
+// GetShare is the public entry point of a link. Password protected links
+// take the password in the X-Share-Password header. The photo URLs in the
+// response are signed, so the browser can load them without the password.
+func GetShare(c *gin.Context) {
+	share, ok := loadShare(c)
+	if !ok {
+		return
+	}
+
+	if share.PasswordHash != "" {
+		password := c.GetHeader("X-Share-Password")
+		if password == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "password required", "password_required": true})
+			return
+		}
+
+		ctx, ip := c.Request.Context(), c.ClientIP()
+		limiter := lockout.Get()
+		wait, err := limiter.AttemptShare(ctx, share.ID.Hex(), ip)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check password"})
+			return
+		}
+		if wait > 0 {
+			tooManyAttempts(c, wait)
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(share.PasswordHash), []byte(password)) != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "wrong password", "password_required": true})
+			return
+		}
+		if err := limiter.SucceedShare(ctx, share.ID.Hex(), ip); err != nil {
+			log.Printf("Failed to reset failed share passwords: %v", err)
+		}
+	}
+
+	if _, err := database.GetShareCollection().UpdateByID(context.Background(), share.ID, bson.M{"$inc": bson.M{"views": 1}}); err != nil {
+		log.Printf("Failed to count share view: %v", err)
+	}
+
+	resp := gin.H{
+		"kind":           share.Kind,
+		"allow_download": share.AllowDownload,
+		"expires_at":     share.ExpiresAt,
+	}
+
+	if share.Kind == models.ShareKindPhoto {
+		photo, ok := findSharedPhoto(c, share, *share.PhotoID)
+		if !ok {
+			return
+		}
+		resp["photo"] = toSharedPhoto(share, *photo)
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	var album models.Album
+	err := database.GetAlbumCollection().FindOne(context.Background(), bson.M{"_id": share.AlbumID}).Decode(&album)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "album not found"})
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "21"))
+	if err != nil || limit < 1 {
+		limit = 21
+	}
+
+	filter := bson.M{
+		"_id":        bson.M{"$in": album.PhotoIDs},
+		"deleted_at": bson.M{"$exists": false},
+	}
+	photos, totalCount, err := listInAlbumOrder(filter, album.PhotoIDs, (page-1)*limit, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch photos"})
+		return
+	}
+
+	shared := make([]sharedPhoto, len(photos))
+	for i, p := range photos {
+		shared[i] = toSharedPhoto(share, p)
+	}
+
+	resp["album"] = gin.H{"name": album.Name, "description": album.Description}
+	resp["page"] = page
+	resp["limit"] = limit
+	resp["photos"] = shared
+	resp["total"] = totalCount
+	resp["totalPages"] = int(math.Ceil(float64(totalCount) / float64(limit)))
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetSharedPhotoContent serves a photo of a share. View-only links get the
+// largest preview rendition instead of the original.
+func GetSharedPhotoContent(c *gin.Context) {
+	share, photo, ok := loadSharedFile(c)
+	if !ok {
+		return
+	}
+
+	if share.AllowDownload {
+		servePhotoFile(c, photo)
+		return
+	}
+	serveRendition(c, photo, renditions.Sizes[len(renditions.Sizes)-1])
+}
+
+func GetSharedThumbnail(c *gin.Context) {
+	_, photo, ok := loadSharedFile(c)
+	if !ok {
+		return
+	}
+
+	requested, err := strconv.Atoi(c.DefaultQuery("size", strconv.Itoa(renditions.Sizes[0])))
+	if err != nil || requested < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid size"})
+		return
+	}
+	serveRendition(c, photo, renditions.Pick(requested))
+}
+
+// loadShare loads the active share in the :token param and writes the
+// error response if there is none.
+func loadShare(c *gin.Context) (*models.Share, bool) {
+	var share models.Share
+	err := database.GetShareCollection().FindOne(context.Background(), bson.M{"token": c.Param("token")}).Decode(&share)
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && share.RevokedAt != 0) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "link not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch link"})
+		return nil, false
+	}
+	if share.ExpiresAt != 0 && time.Now().Unix() > share.ExpiresAt {
+		c.JSON(http.StatusGone, gin.H{"error": "link expired"})
+		return nil, false
+	}
+
+	// Links of a disabled account stop working, and work again if it is
+	// enabled
+	disabled, err := database.GetUserCollection().CountDocuments(context.Background(),
+		bson.M{"_id": share.UserID, "disabled_at": bson.M{"$exists": true}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch link"})
+		return nil, false
+	}
+	if disabled > 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "link not found"})
+		return nil, false
+	}
+	return &share, true
+}
+
+// loadSharedFile checks a file request under /s/:token/photos/:photoId.
+// Links with a password need the signed URL handed out by GetShare.
+func loadSharedFile(c *gin.Context) (*models.Share, *models.Photo, bool) {
+	share, ok := loadShare(c)
+	if !ok {
+		return nil, nil, false
+	}
+
+	if share.PasswordHash != "" {
+		uid, err := utils.VerifySignedURL(c.Request.URL.Path, c.Request.URL.Query())
+		if err != nil || uid != shareSigner(share) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired link"})
+			return nil, nil, false
+		}
+	}
+
+	photoID, err := primitive.ObjectIDFromHex(c.Param("photoId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid photo ID"})
+		return nil, nil, false
+	}
+
+	photo, ok := findSharedPhoto(c, share, photoID)
+	if !ok {
+		return nil, nil, false
+	}
+	return share, photo, true
+}
+
+// findSharedPhoto loads a photo if the share covers it.
+func findSharedPhoto(c *gin.Context, share *models.Share, photoID primitive.ObjectID) (*models.Photo, bool) {
+	covered := false
+	switch share.Kind {
+	case models.ShareKindPhoto:
+		covered = *share.PhotoID == photoID
+	case models.ShareKindAlbum:
+		n, err := database.GetAlbumCollection().CountDocuments(context.Background(),
+			bson.M{"_id": share.AlbumID, "photo_ids": photoID})
+		covered = err == nil && n > 0
+	}
+	if !covered {
+		c.JSON(http.StatusNotFound, gin.H{"error": "photo not found"})
+		return nil, false
+	}
+
+	var photo models.Photo
+	err := database.GetPhotoCollection().FindOne(context.Background(),
+		bson.M{"_id": photoID, "deleted_at": bson.M{"$exists": false}},
+	).Decode(&photo)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "photo not found"})
+		return nil, false
+	}
+	return &photo, true
+}
+
+func toSharedPhoto(share *models.Share, photo models.Photo) sharedPhoto {
+	base := "/s/" + share.Token + "/photos/" + photo.ID.Hex()
+	return sharedPhoto{
+		ID:           photo.ID,
+		Name:         photo.Name,
+		TakenAt:      photo.TakenAt,
+		URL:          shareFileURL(share, base+"/content"),
+		ThumbnailURL: shareFileURL(share, base+"/thumbnail") + sizeParam(share),
+	}
+}
+
+// shareFileURL signs file URLs of password protected links, for no longer
+// than the link itself is valid. Open links don't need a signature.
+func shareFileURL(share *models.Share, path string) string {
+	if share.PasswordHash == "" {
+		return path
+	}
+
+	ttl := utils.SignedURLTTL()
+	if share.ExpiresAt != 0 {
+		ttl = min(ttl, time.Until(time.Unix(share.ExpiresAt, 0)))
+	}
+	return utils.SignURL(path, shareSigner(share), ttl)
+}
+
+func sizeParam(share *models.Share) string {
+	sep := "?"
+	if share.PasswordHash != "" {
+		sep = "&"
+	}
+	return sep + "size=" + strconv.Itoa(renditions.Sizes[0])
+}
+
+func shareSigner(share *models.Share) string {
+	return "share:" + share.ID.Hex()
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"photo-storage-backend/database"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxTagLength      = 50
+	maxTagsPerRequest = 20
+)
+
+type tagRequest struct {
+	IDs  []string `json:"ids"`
+	Tags []string `json:"tags"`
+}
+
+// AddTags adds tags to one or more of the caller's photos.
+func AddTags(c *gin.Context) {
+	updateTags(c, func(tags []string) bson.M {
+		return bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": tags}}}
+	})
+}
+
+// RemoveTags removes tags from one or more of the caller's photos.
+func RemoveTags(c *gin.Context) {
+	updateTags(c, func(tags []string) bson.M {
+		return bson.M{"$pull": bson.M{"tags": bson.M{"$in": tags}}}
+	})
+}
+
+func updateTags(c *gin.Context, update func(tags []string) bson.M) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	var body tagRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	objectIDs := parseObjectIDs(body.IDs)
+	if len(objectIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no valid IDs provided"})
+		return
+	}
+
+	tags := normalizeTags(body.Tags)
+	if len(tags) == 0 || len(tags) > maxTagsPerRequest {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provide between 1 and 20 tags"})
+		return
+	}
+
+	filter := bson.M{
+		"_id":     bson.M{"$in": objectIDs},
+		"user_id": userID,
+	}
+
+	res, err := database.GetPhotoCollection().UpdateMany(context.Background(), filter, update(tags))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update tags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "tags": tags, "updated_count": res.ModifiedCount})
+}
+
+// SetFavorite marks or unmarks one or more of the caller's photos as
+// favorites.
+func SetFavorite(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	var body struct {
+		IDs      []string `json:"ids"`
+		Favorite bool     `json:"favorite"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	objectIDs := parseObjectIDs(body.IDs)
+	if len(objectIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no valid IDs provided"})
+		return
+	}
+
+	filter := bson.M{
+		"_id":     bson.M{"$in": objectIDs},
+		"user_id": userID,
+	}
+	update := bson.M{
+		"$set": bson.M{"favorite": body.Favorite},
+	}
+
+	res, err := database.GetPhotoCollection().UpdateMany(context.Background(), filter, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update favorites"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "updated_count": res.ModifiedCount})
+}
+
+// ListTags returns every tag the caller uses with the number of photos
+// carrying it, most used first.
+func ListTags(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{
+			"user_id":    userID,
+			"deleted_at": bson.M{"$exists": false},
+			"tags.0":     bson.M{"$exists": true},
+		}},
+		bson.M{"$unwind": "$tags"},
+		bson.M{"$group": bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}},
+		bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+	}
+
+	cursor, err := database.GetPhotoCollection().Aggregate(context.Background(), pipeline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch tags"})
+		return
+	}
+
+	var rows []struct {
+		Tag   string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	if err := cursor.All(context.Background(), &rows); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode tags"})
+		return
+	}
+
+	tags := make([]gin.H, len(rows))
+	for i, r := range rows {
+		tags[i] = gin.H{"tag": r.Tag, "count": r.Count}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+// normalizeTags lower-cases tags, collapses whitespace and drops empty,
+// overlong and repeated ones.
+func normalizeTags(raw []string) []string {
+	seen := make(map[string]bool, len(raw))
+	tags := make([]string, 0, len(raw))
+	for _, t := range raw {
+		t = strings.ToLower(strings.Join(strings.Fields(t), " "))
+		if t == "" || utf8.RuneCountInString(t) > maxTagLength || seen[t] {
+			continue
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	return tags
+}
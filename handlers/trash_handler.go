@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"photo-storage-backend/cleanup"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeletePhoto moves one photo to the trash.
+func DeletePhoto(c *gin.Context) {
+	photoID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid photo ID"})
+		return
+	}
+
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	moved, err := trashPhotos(userID, []primitive.ObjectID{photoID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete photo"})
+		return
+	}
+	if moved == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "photo not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// TrashPhotos moves several photos to the trash.
+func TrashPhotos(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	objectIDs, ok := bindObjectIDs(c)
+	if !ok {
+		return
+	}
+
+	moved, err := trashPhotos(userID, objectIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete photos"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "deleted_count": moved})
+}
+
+func trashPhotos(userID primitive.ObjectID, ids []primitive.ObjectID) (int64, error) {
+	filter := bson.M{
+		"_id":        bson.M{"$in": ids},
+		"user_id":    userID,
+		"deleted_at": bson.M{"$exists": false},
+	}
+	update := bson.M{
+		"$set": bson.M{"deleted_at": time.Now().Unix()},
+	}
+
+	res, err := database.GetPhotoCollection().UpdateMany(context.Background(), filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// ListTrash lists the caller's trashed photos, most recently deleted first,
+// in the same shape as ListPhotos.
+func ListTrash(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "21"))
+	if err != nil || limit < 1 {
+		limit = 21
+	}
+
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	collection := database.GetPhotoCollection()
+	filter := bson.M{
+		"user_id":    userID,
+		"deleted_at": bson.M{"$exists": true},
+	}
+
+	totalCount, err := collection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count documents"})
+		return
+	}
+
+	findOptions := options.Find().
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit)).
+		SetSort(bson.D{{Key: "deleted_at", Value: -1}, {Key: "_id", Value: -1}})
+
+	cursor, err := collection.Find(context.Background(), filter, findOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch photos"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	photos := []models.Photo{}
+	if err := cursor.All(context.Background(), &photos); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode results"})
+		return
+	}
+
+	setPhotoURLs(photos, userID.Hex())
+
+	retention := cleanup.TrashRetention()
+	c.JSON(http.StatusOK, gin.H{
+		"page":           page,
+		"limit":          limit,
+		"photos":         photos,
+		"total":          totalCount,
+		"totalPages":     int(math.Ceil(float64(totalCount) / float64(limit))),
+		"retention_days": int(retention.Hours() / 24),
+	})
+}
+
+// RestorePhotos takes photos back out of the trash.
+func RestorePhotos(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	objectIDs, ok := bindObjectIDs(c)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{
+		"_id":        bson.M{"$in": objectIDs},
+		"user_id":    userID,
+		"deleted_at": bson.M{"$exists": true},
+	}
+	update := bson.M{
+		"$unset": bson.M{"deleted_at": ""},
+	}
+
+	res, err := database.GetPhotoCollection().UpdateMany(context.Background(), filter, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore photos"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "restored_count": res.ModifiedCount})
+}
+
+// PurgeTrash permanently deletes trashed photos right away instead of
+// waiting for the retention period. With no IDs the whole trash is emptied.
+func PurgeTrash(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	// No body empties the whole trash, but a body we can't read must not
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	filter := bson.M{
+		"user_id":    userID,
+		"deleted_at": bson.M{"$exists": true},
+	}
+	if len(body.IDs) > 0 {
+		objectIDs := parseObjectIDs(body.IDs)
+		if len(objectIDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no valid IDs provided"})
+			return
+		}
+		filter["_id"] = bson.M{"$in": objectIDs}
+	}
+
+	cursor, err := database.GetPhotoCollection().Find(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch photos"})
+		return
+	}
+	var photos []models.Photo
+	if err := cursor.All(context.Background(), &photos); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode results"})
+		return
+	}
+
+	// Only photos still in the trash, one may have been restored meanwhile
+	purged, err := cleanup.PurgePhotos(context.Background(), photos, bson.M{"deleted_at": bson.M{"$exists": true}})
+	if err != nil {
+		log.Printf("Failed to purge trash: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to purge photos"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "purged_count": len(purged)})
+}
+
+// bindObjectIDs reads a {"ids": [...]} body, skipping invalid IDs, and
+// writes the error response if nothing usable is left.
+func bindObjectIDs(c *gin.Context) ([]primitive.ObjectID, bool) {
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return nil, false
+	}
+
+	objectIDs := parseObjectIDs(body.IDs)
+	if len(objectIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no valid IDs provided"})
+		return nil, false
+	}
+	return objectIDs, true
+}
+
+func parseObjectIDs(ids []string) []primitive.ObjectID {
+	objectIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, idStr := range ids {
+		objID, err := primitive.ObjectIDFromHex(idStr)
+		if err != nil {
+			continue // skip invalid IDs
+		}
+		objectIDs = append(objectIDs, objID)
+	}
+	return objectIDs
+}
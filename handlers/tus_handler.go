@@ -0,0 +1,453 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"photo-storage-backend/database"
+	"photo-storage-backend/messaging"
+	"photo-storage-backend/middleware"
+	"photo-storage-backend/models"
+	"photo-storage-backend/renditions"
+	"photo-storage-backend/repository"
+	"photo-storage-backend/storage"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Resumable uploads following tus 1.0 (https://tus.io/protocols/resumable-upload),
+// with the creation, termination and expiration extensions. A client
+// creates an upload with POST, sends the bytes with as many PATCH requests
+// as it takes, and can ask for the offset to resume from with HEAD.
+//
+// Upload-Metadata keys we use: filename, filetype, album_id, batch_id and
+// sha256.
+// Uploads that name the same batch_id share one notification, like the
+// files of one UploadPhotos request.
+
+const tusVersion = "1.0.0"
+
+func tusUploadTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("TUS_UPLOAD_TTL"))
+	if err != nil || ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return ttl
+}
+
+// TusResumable checks the protocol version on every tus request except
+// OPTIONS and sets the version header on the response.
+func TusResumable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", tusVersion)
+		if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tusVersion {
+			c.Header("Tus-Version", tusVersion)
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "unsupported tus version"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// TusOptions describes what the server supports.
+func TusOptions(c *gin.Context) {
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", "creation,termination,expiration")
+	c.Header("Tus-Max-Size", strconv.FormatInt(maxUploadFileBytes(), 10))
+	c.Status(http.StatusNoContent)
+}
+
+// CreateTusUpload starts an upload. The full length has to be known up
+// front; it is reserved against the quota right away.
+func CreateTusUpload(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length is required"})
+		return
+	}
+	if length > maxUploadFileBytes() {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file is too large", "max_bytes": maxUploadFileBytes()})
+		return
+	}
+
+	meta, ok := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Metadata"})
+		return
+	}
+	if meta["filename"] == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "filename metadata is required"})
+		return
+	}
+	digest := ""
+	if s, ok := meta["sha256"]; ok {
+		if digest, ok = parseDigest(s); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidDigest.Error()})
+			return
+		}
+	}
+
+	now := time.Now()
+	upload := models.Upload{
+		ID:          primitive.NewObjectID(),
+		UserID:      userID,
+		BatchID:     primitive.NewObjectID(),
+		Filename:    sanitizeFilename(meta["filename"]),
+		ContentType: meta["filetype"],
+		SHA256:      digest,
+		Length:      length,
+		CreatedAt:   now.Unix(),
+		ExpiresAt:   now.Add(tusUploadTTL()).Unix(),
+		Status:      models.UploadStatusUploading,
+	}
+	if s := meta["batch_id"]; s != "" {
+		if upload.BatchID, err = primitive.ObjectIDFromHex(s); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid batch_id"})
+			return
+		}
+	}
+	if s := meta["album_id"]; s != "" {
+		if !middleware.HasScope(c, models.ScopeAlbumsWrite) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "token is missing the " + models.ScopeAlbumsWrite + " scope"})
+			return
+		}
+		album, ok := loadAlbum(c, s, userID, models.AlbumRoleContributor)
+		if !ok {
+			return
+		}
+		upload.AlbumID = &album.ID
+	}
+
+	if !reserveQuota(c, userID, length, 1) {
+		return
+	}
+
+	f, err := os.OpenFile(storage.UploadPath(upload.ID.Hex()), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		releaseQuota(userID, length, 1)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create upload"})
+		return
+	}
+	f.Close()
+
+	if _, err := database.GetUploadCollection().InsertOne(context.Background(), upload); err != nil {
+		os.Remove(storage.UploadPath(upload.ID.Hex()))
+		releaseQuota(userID, length, 1)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create upload"})
+		return
+	}
+
+	c.Header("Location", "/api/tus/"+upload.ID.Hex())
+	c.Header("Upload-Expires", time.Unix(upload.ExpiresAt, 0).UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+// HeadTusUpload tells the client where to resume.
+func HeadTusUpload(c *gin.Context) {
+	upload, ok := findUpload(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Upload-Expires", time.Unix(upload.ExpiresAt, 0).UTC().Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+}
+
+// GetTusUpload returns the upload as JSON, including the resulting photo
+// once it is complete. Not part of tus.
+func GetTusUpload(c *gin.Context) {
+	upload, ok := findUpload(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, upload)
+}
+
+// PatchTusUpload appends bytes at Upload-Offset. When the last byte
+// arrives, the file becomes a photo just like one sent to UploadPhotos.
+func PatchTusUpload(c *gin.Context) {
+	upload, ok := findUpload(c)
+	if !ok {
+		return
+	}
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset is required"})
+		return
+	}
+
+	unlock, ok := repository.LockUpload(upload.ID)
+	if !ok {
+		c.JSON(http.StatusLocked, gin.H{"error": "upload is busy"})
+		return
+	}
+	defer unlock()
+
+	// Read again under the lock, a request that just finished may have
+	// moved it on
+	if err := database.GetUploadCollection().FindOne(context.Background(), bson.M{"_id": upload.ID}).Decode(upload); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+		return
+	}
+	if upload.Status != models.UploadStatusUploading {
+		c.JSON(http.StatusConflict, gin.H{"error": "upload is already complete"})
+		return
+	}
+	if offset != upload.Offset {
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.JSON(http.StatusConflict, gin.H{"error": "offset mismatch", "offset": upload.Offset})
+		return
+	}
+
+	written, err := appendUploadChunk(upload, c.Request.Body)
+	if written > 0 {
+		upload.Offset += written
+		if _, dbErr := database.GetUploadCollection().UpdateByID(context.Background(), upload.ID,
+			bson.M{"$set": bson.M{"offset": upload.Offset}}); dbErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save offset"})
+			return
+		}
+	}
+	if errors.Is(err, errUploadTooLong) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "more bytes than Upload-Length"})
+		return
+	}
+	if err != nil {
+		// The client resumes from whatever did get written
+		log.Printf("Upload %s interrupted at %d: %v", upload.ID.Hex(), upload.Offset, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to write chunk"})
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Expires", time.Unix(upload.ExpiresAt, 0).UTC().Format(http.TimeFormat))
+	if upload.Offset == upload.Length {
+		// Claim the upload first, so a DELETE or the sweeper can't give
+		// back its quota while it becomes a photo
+		res, err := database.GetUploadCollection().UpdateOne(context.Background(),
+			bson.M{"_id": upload.ID, "status": models.UploadStatusUploading},
+			bson.M{"$set": bson.M{"status": models.UploadStatusProcessing}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to finish upload"})
+			return
+		}
+		if res.ModifiedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+			return
+		}
+
+		// Don't let a client that hangs up now leave the photo half made
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		finishUpload(ctx, upload)
+		cancel()
+		repository.DropUploadLock(upload.ID)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteTusUpload cancels an unfinished upload.
+func DeleteTusUpload(c *gin.Context) {
+	upload, ok := findUpload(c)
+	if !ok {
+		return
+	}
+	if upload.Status != models.UploadStatusUploading {
+		c.JSON(http.StatusConflict, gin.H{"error": "upload is already complete"})
+		return
+	}
+
+	// Wait out a PATCH in progress, it may be the last one
+	unlock, ok := repository.LockUpload(upload.ID)
+	if !ok {
+		c.JSON(http.StatusLocked, gin.H{"error": "upload is busy"})
+		return
+	}
+	defer unlock()
+
+	discarded, err := repository.DiscardUpload(c.Request.Context(), upload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete upload"})
+		return
+	}
+	if !discarded {
+		c.JSON(http.StatusConflict, gin.H{"error": "upload is already complete"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+var errUploadTooLong = errors.New("more bytes than Upload-Length")
+
+// appendUploadChunk writes r at the upload's offset and returns how many
+// bytes made it to disk. Anything after a previously recorded offset is
+// left over from a write that failed and is overwritten.
+func appendUploadChunk(upload *models.Upload, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(storage.UploadPath(upload.ID.Hex()), os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if err := f.Truncate(upload.Offset); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(upload.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	remaining := upload.Length - upload.Offset
+	n, err := io.Copy(f, io.LimitReader(r, remaining))
+	if err != nil {
+		return n, err
+	}
+	if n == remaining {
+		// The client may not send more than it announced
+		if extra, _ := r.Read(make([]byte, 1)); extra > 0 {
+			return n, errUploadTooLong
+		}
+	}
+	return n, nil
+}
+
+// finishUpload turns a complete upload into a photo, or finds it is a
+// duplicate, and records the outcome on the upload. The quota reserved for
+// it is kept only if a new photo was stored.
+func finishUpload(ctx context.Context, upload *models.Upload) {
+	set := bson.M{}
+	defer func() {
+		if _, err := database.GetUploadCollection().UpdateByID(context.Background(), upload.ID, bson.M{"$set": set}); err != nil {
+			log.Printf("Failed to update upload %s: %v", upload.ID.Hex(), err)
+		}
+		if err := os.Remove(storage.UploadPath(upload.ID.Hex())); err != nil {
+			log.Printf("Failed to remove upload file %s: %v", upload.ID.Hex(), err)
+		}
+	}()
+	fail := func(err error) {
+		log.Printf("Failed to store upload %s (%s): %v", upload.ID.Hex(), upload.Filename, err)
+		releaseQuota(upload.UserID, upload.Length, 1)
+		set["status"] = models.UploadStatusFailed
+		set["error"] = uploadFailureReason(err)
+	}
+
+	f, err := os.Open(storage.UploadPath(upload.ID.Hex()))
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer f.Close()
+
+	batch := newPhotoBatch(upload.UserID, upload.BatchID)
+	photo, dup, err := batch.add(ctx, upload.Filename, upload.SHA256, f)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	var photoID primitive.ObjectID
+	if dup == nil {
+		inserted, raced, _ := batch.insert(ctx, []models.Photo{*photo})
+		switch {
+		case len(inserted) == 1:
+			photoID = inserted[0].ID
+		case len(raced) == 1:
+			dup = &raced[0]
+		default:
+			fail(errSavePhoto)
+			return
+		}
+	}
+
+	if dup != nil {
+		releaseQuota(upload.UserID, upload.Length, 1)
+		photoID = dup.PhotoID
+		set["status"] = models.UploadStatusDuplicate
+	} else {
+		set["status"] = models.UploadStatusCompleted
+	}
+	set["photo_id"] = photoID
+	upload.PhotoID = &photoID
+
+	if upload.AlbumID != nil {
+		if err := addToAlbum(ctx, *upload.AlbumID, []primitive.ObjectID{photoID}); err != nil {
+			log.Printf("Failed to add upload %s to album %s: %v", upload.ID.Hex(), upload.AlbumID.Hex(), err)
+		}
+	}
+	if dup != nil {
+		return
+	}
+
+	// Same follow-up as UploadPhotos, one photo at a time
+	renditions.Enqueue(*photo)
+	if err := joinBatchNotification(ctx, upload.UserID, upload.BatchID, 1); err != nil {
+		log.Printf("Failed to update notification: %v", err)
+	}
+	go func(p models.Photo) {
+		if err := messaging.PublishEmbeddingJob(messaging.RabbitURL(), []models.Photo{p}); err != nil {
+			log.Printf("Failed to publish embedding job: %v", err)
+		}
+	}(*photo)
+}
+
+// findUpload loads the caller's upload in the :id param, writing the
+// error response if it can't.
+func findUpload(c *gin.Context) (*models.Upload, bool) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	uploadID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+		return nil, false
+	}
+
+	var upload models.Upload
+	err = database.GetUploadCollection().FindOne(context.Background(), bson.M{"_id": uploadID, "user_id": userID}).Decode(&upload)
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && time.Now().Unix() > upload.ExpiresAt) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch upload"})
+		return nil, false
+	}
+	return &upload, true
+}
+
+// parseTusMetadata parses "key base64value,key2 base64value2".
+func parseTusMetadata(header string) (map[string]string, bool) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, false
+		}
+		meta[key] = string(value)
+	}
+	return meta, true
+}
@@ -0,0 +1,511 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"photo-storage-backend/database"
+	"photo-storage-backend/messaging"
+	"photo-storage-backend/middleware"
+	"photo-storage-backend/models"
+	"photo-storage-backend/renditions"
+	"photo-storage-backend/repository"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Photos saved and sent to the inference service at a time while a batch
+// streams in
+const uploadFlushSize = 20
+
+var (
+	errFileTooLarge     = errors.New("file is too large")
+	errAlbumAfterPhotos = errors.New("album_id must come before the photos")
+	errSavePhoto        = errors.New("failed to save photo")
+)
+
+// maxUploadFileBytes is the largest single file we accept.
+func maxUploadFileBytes() int64 {
+	n, err := strconv.ParseInt(os.Getenv("MAX_UPLOAD_FILE_BYTES"), 10, 64)
+	if err != nil || n <= 0 {
+		n = 200 << 20
+	}
+	return n
+}
+
+// maxUploadRequestBytes is the largest UploadPhotos request we accept.
+func maxUploadRequestBytes() int64 {
+	n, err := strconv.ParseInt(os.Getenv("MAX_UPLOAD_REQUEST_BYTES"), 10, 64)
+	if err != nil || n <= 0 {
+		n = 4 << 30
+	}
+	return n
+}
+
+// UploadPhotos stores the files in the "photos" parts of a multipart form.
+// The form is read part by part, so each file is hashed and stored as it
+// arrives and nothing waits for the whole form. An album to upload into can
+// be given as ?album_id= or as an album_id field before the photos. A
+// sha256 field right before a photo is checked against that photo's content.
+//
+// Clients that send Accept: application/x-ndjson get a line per file as it
+// is done and a summary line at the end, which keeps large batches from
+// timing out. Everyone else gets the summary as one JSON response.
+func UploadPhotos(c *gin.Context) {
+	userIDStr, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(userIDStr.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	if c.Request.ContentLength > maxUploadRequestBytes() {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request is too large", "max_bytes": maxUploadRequestBytes()})
+		return
+	}
+
+	var user models.User
+	err = database.GetUserCollection().FindOne(context.Background(),
+		bson.M{"_id": userID},
+		options.FindOne().SetProjection(bson.M{"usage": 1, "quota_bytes": 1, "quota_photos": 1}),
+	).Decode(&user)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+		return
+	}
+	quota := repository.QuotaFor(&user)
+
+	// Turn the upload away before reading any of it if the caller has no
+	// room left at all. The body also carries multipart framing and files
+	// that may turn out to be duplicates, so the reservation for each file
+	// as it is stored is the exact check.
+	if !quota.Allows(user.Usage, 1, 1) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "storage quota exceeded", "usage": user.Usage, "quota": quota})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadRequestBytes())
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to parse form data"})
+		return
+	}
+
+	var album *models.Album
+	if albumIDStr := c.Query("album_id"); albumIDStr != "" {
+		var ok bool
+		if album, ok = uploadAlbum(c, albumIDStr, userID); !ok {
+			return
+		}
+	}
+
+	// The client may hang up halfway, what arrived by then is still saved
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	batchID := primitive.NewObjectID()
+	batch := newPhotoBatch(userID, batchID)
+	batch.quota = &quota
+	out := &uploadOutput{c: c, userID: userIDStr.(string), stream: acceptsNDJSON(c)}
+
+	var pending []models.Photo
+	var albumPhotoIDs []primitive.ObjectID
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		inserted, raced, notSaved := batch.insert(ctx, pending)
+		pending = nil
+
+		for _, d := range raced {
+			albumPhotoIDs = append(albumPhotoIDs, d.PhotoID)
+			out.duplicate(d)
+		}
+		for _, p := range notSaved {
+			out.failed(p.Name, errSavePhoto)
+		}
+		if len(inserted) == 0 {
+			return
+		}
+
+		for _, p := range inserted {
+			albumPhotoIDs = append(albumPhotoIDs, p.ID)
+			renditions.Enqueue(p)
+		}
+		if err := joinBatchNotification(ctx, userID, batchID, len(inserted)); err != nil {
+			log.Printf("Failed to update notification: %v", err)
+		}
+		go func(photos []models.Photo) {
+			if err := messaging.PublishEmbeddingJob(messaging.RabbitURL(), photos); err != nil {
+				log.Printf("Failed to publish embedding job: %v", err)
+			} else {
+				log.Printf("Embedding job queued for %d photos (user: %s)", len(photos), photos[0].UserID.Hex())
+			}
+		}(inserted)
+		out.uploaded(inserted)
+	}
+
+	files := 0
+	var readErr error
+	var digest string
+	for readErr == nil {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+
+		switch {
+		case part.FormName() == "album_id" && part.FileName() == "":
+			if files > 0 {
+				readErr = errAlbumAfterPhotos
+				break
+			}
+			value, _ := io.ReadAll(io.LimitReader(part, 64))
+			var ok bool
+			if album, ok = uploadAlbum(c, strings.TrimSpace(string(value)), userID); !ok {
+				part.Close()
+				return
+			}
+
+		case part.FormName() == "sha256" && part.FileName() == "":
+			value, _ := io.ReadAll(io.LimitReader(part, 128))
+			digest = strings.TrimSpace(string(value))
+
+		case part.FormName() == "photos" && part.FileName() != "":
+			files++
+			name := sanitizeFilename(part.FileName())
+			photo, dup, err := batch.add(ctx, name, digest, &fileLimitReader{r: part, n: maxUploadFileBytes()})
+			digest = ""
+			switch {
+			case err != nil:
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					readErr = err
+				}
+				log.Printf("Failed to store %s: %v", name, err)
+				out.failed(name, err)
+			case dup != nil:
+				albumPhotoIDs = append(albumPhotoIDs, dup.PhotoID)
+				out.duplicate(*dup)
+			default:
+				pending = append(pending, *photo)
+				if len(pending) >= uploadFlushSize {
+					flush()
+				}
+			}
+		}
+		part.Close()
+	}
+	flush()
+
+	if album != nil && len(albumPhotoIDs) > 0 {
+		if err := addToAlbum(ctx, album.ID, albumPhotoIDs); err != nil {
+			log.Printf("Failed to add uploaded photos to album %s: %v", album.ID.Hex(), err)
+		}
+	}
+
+	if files == 0 && readErr == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no photos uploaded"})
+		return
+	}
+	out.finish(batchID, readErr)
+}
+
+// Most hashes CheckPhotos takes at once
+const maxCheckHashes = 1000
+
+type photoMatch struct {
+	Hash    string             `json:"hash"`
+	PhotoID primitive.ObjectID `json:"photo_id"`
+	Size    int64              `json:"size"`
+	InTrash bool               `json:"in_trash"`
+}
+
+// CheckPhotos tells sync clients which files they don't need to upload.
+// It takes SHA-256 hashes of file contents, optionally with the sizes of
+// the files in the same order, and returns the ones already in the
+// caller's library with their photo IDs. Trashed photos count, since
+// uploading them again just restores them. When sizes are given, a hash
+// only matches a photo of that size.
+func CheckPhotos(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	var body struct {
+		Hashes []string `json:"hashes"`
+		Sizes  []int64  `json:"sizes"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if len(body.Hashes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hashes are required"})
+		return
+	}
+	if len(body.Hashes) > maxCheckHashes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many hashes", "max": maxCheckHashes})
+		return
+	}
+	if body.Sizes != nil && len(body.Sizes) != len(body.Hashes) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sizes must match hashes one to one"})
+		return
+	}
+
+	hashes := make([]string, len(body.Hashes))
+	for i, h := range body.Hashes {
+		digest, ok := parseDigest(h)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid hash", "hash": h})
+			return
+		}
+		hashes[i] = digest
+	}
+
+	cursor, err := database.GetPhotoCollection().Find(context.Background(),
+		bson.M{"user_id": userID, "hash": bson.M{"$in": hashes}},
+		options.Find().SetProjection(bson.M{"_id": 1, "hash": 1, "size": 1, "deleted_at": 1}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch photos"})
+		return
+	}
+	var photos []models.Photo
+	if err := cursor.All(context.Background(), &photos); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode results"})
+		return
+	}
+
+	byHash := make(map[string]models.Photo, len(photos))
+	for _, p := range photos {
+		byHash[p.Hash] = p
+	}
+
+	matches := []photoMatch{}
+	missing := []string{}
+	for i, h := range hashes {
+		p, ok := byHash[h]
+		if ok && body.Sizes != nil && p.Size != body.Sizes[i] {
+			ok = false
+		}
+		if !ok {
+			missing = append(missing, h)
+			continue
+		}
+		matches = append(matches, photoMatch{Hash: h, PhotoID: p.ID, Size: p.Size, InTrash: p.DeletedAt != 0})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"matches": matches, "missing": missing})
+}
+
+// uploadAlbum loads the album to upload into, which the caller must be
+// allowed to add to.
+func uploadAlbum(c *gin.Context, albumIDStr string, userID primitive.ObjectID) (*models.Album, bool) {
+	if !middleware.HasScope(c, models.ScopeAlbumsWrite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "token is missing the " + models.ScopeAlbumsWrite + " scope"})
+		return nil, false
+	}
+	return loadAlbum(c, albumIDStr, userID, models.AlbumRoleContributor)
+}
+
+// uploadFailureReason is what the client is told about a file that
+// could not be stored.
+func uploadFailureReason(err error) string {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case isRejection(err), errors.Is(err, errQuotaExceeded), errors.Is(err, errFileTooLarge), errors.Is(err, errSavePhoto):
+		return err.Error()
+	case errors.As(err, &maxBytesErr):
+		return "request is too large"
+	}
+	return "failed to store file"
+}
+
+// isRejection reports whether a file failed validation, as opposed to us
+// failing to store it.
+func isRejection(err error) bool {
+	return errors.Is(err, errUnsupportedType) || errors.Is(err, errCorruptImage) || errors.Is(err, errTooManyPixels) ||
+		errors.Is(err, errInvalidDigest) || errors.Is(err, errDigestMismatch)
+}
+
+type failedFile struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// fileLimitReader fails once a file goes past n bytes.
+type fileLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *fileLimitReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, errFileTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errFileTooLarge
+	}
+	return n, err
+}
+
+func acceptsNDJSON(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), "application/x-ndjson")
+}
+
+// uploadOutput collects the outcome of each file. When streaming, each
+// one is also written out as a line right away.
+type uploadOutput struct {
+	c      *gin.Context
+	userID string
+	stream bool
+
+	uploadedPhotos []models.Photo
+	duplicates     []duplicatePhoto
+	failedFiles    []failedFile
+	tooLarge       bool
+	storeFailed    bool
+	started        bool
+}
+
+func (o *uploadOutput) uploaded(photos []models.Photo) {
+	setPhotoURLs(photos, o.userID)
+	o.uploadedPhotos = append(o.uploadedPhotos, photos...)
+	for _, p := range photos {
+		o.line(gin.H{"type": "uploaded", "photo": p})
+	}
+}
+
+func (o *uploadOutput) duplicate(d duplicatePhoto) {
+	o.duplicates = append(o.duplicates, d)
+	o.line(gin.H{"type": "duplicate", "duplicate": d})
+}
+
+func (o *uploadOutput) failed(name string, err error) {
+	reason := uploadFailureReason(err)
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errQuotaExceeded), errors.Is(err, errFileTooLarge), errors.As(err, &maxBytesErr):
+		o.tooLarge = true
+	case !isRejection(err):
+		o.storeFailed = true
+	}
+	o.failedFiles = append(o.failedFiles, failedFile{Name: name, Reason: reason})
+	o.line(gin.H{"type": "failed", "name": name, "reason": reason})
+}
+
+func (o *uploadOutput) line(v gin.H) {
+	if !o.stream {
+		return
+	}
+	if !o.started {
+		o.c.Header("Content-Type", "application/x-ndjson")
+		o.c.Header("X-Content-Type-Options", "nosniff")
+		o.c.Status(http.StatusOK)
+		o.started = true
+	}
+	if err := json.NewEncoder(o.c.Writer).Encode(v); err != nil {
+		return
+	}
+	o.c.Writer.Flush()
+}
+
+// finish writes the summary, in the same shape for both kinds of response.
+func (o *uploadOutput) finish(batchID primitive.ObjectID, readErr error) {
+	if o.uploadedPhotos == nil {
+		o.uploadedPhotos = []models.Photo{}
+	}
+	summary := gin.H{
+		"uploaded_count":  len(o.uploadedPhotos),
+		"failed_count":    len(o.failedFiles),
+		"duplicate_count": len(o.duplicates),
+		"uploaded":        o.uploadedPhotos,
+		"failed_files":    o.failedFiles,
+		"duplicates":      o.duplicates,
+	}
+
+	status := http.StatusOK
+	switch {
+	case len(o.uploadedPhotos) > 0:
+		summary["message"] = "batch upload completed"
+		summary["batch_id"] = batchID
+	case len(o.duplicates) > 0:
+		// Nothing new to embed, so no notification and no job
+		summary["message"] = "photos already exist"
+	case o.storeFailed:
+		status = http.StatusInternalServerError
+		summary["error"] = "no valid files to upload"
+	case o.tooLarge:
+		status = http.StatusRequestEntityTooLarge
+		summary["error"] = "no valid files to upload"
+	default:
+		// Every file was rejected, see failed_files for why
+		status = http.StatusBadRequest
+		summary["error"] = "no valid files to upload"
+	}
+
+	if readErr != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(readErr, &maxBytesErr) {
+			status = http.StatusRequestEntityTooLarge
+			summary["error"] = "request is too large"
+		} else {
+			if status == http.StatusOK {
+				status = http.StatusBadRequest
+			}
+			summary["error"] = readErr.Error()
+		}
+	}
+
+	if o.stream {
+		summary["type"] = "done"
+		summary["status"] = status
+		o.line(summary)
+		return
+	}
+	o.c.JSON(status, summary)
+}
+
+// joinBatchNotification counts n more photos in the batch's notification,
+// creating it for the batch's first photos. Batches grow as uploads come
+// in, so the total is only final once the last of them is saved.
+func joinBatchNotification(ctx context.Context, userID, batchID primitive.ObjectID, n int) error {
+	_, err := database.GetNotificationCollection().UpdateOne(ctx,
+		bson.M{"user_id": userID, "batch_id": batchID},
+		bson.M{
+			"$inc": bson.M{"total": n},
+			"$set": bson.M{"status": "pending", "message": "Embedding started...", "read": false},
+			"$setOnInsert": bson.M{
+				"_id":        primitive.NewObjectID(),
+				"created_at": time.Now().Unix(),
+				"completed":  0,
+				"failed":     0,
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+	"photo-storage-backend/repository"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetMyUsage reports the caller's storage usage against their quota,
+// broken down by upload month and by album. A photo in several albums
+// counts for each of them.
+func GetMyUsage(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	monthPipeline := bson.A{
+		bson.M{"$match": bson.M{"user_id": user.ID}},
+		bson.M{"$group": bson.M{
+			"_id": bson.M{"$dateToString": bson.M{
+				"format": "%Y-%m",
+				"date":   bson.M{"$toDate": bson.M{"$multiply": bson.A{"$upload_at", 1000}}},
+			}},
+			"bytes":  bson.M{"$sum": "$size"},
+			"photos": bson.M{"$sum": 1},
+		}},
+		bson.M{"$sort": bson.M{"_id": -1}},
+	}
+	cursor, err := database.GetPhotoCollection().Aggregate(ctx, monthPipeline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute usage"})
+		return
+	}
+	byMonth := []struct {
+		Month  string `bson:"_id" json:"month"`
+		Bytes  int64  `bson:"bytes" json:"bytes"`
+		Photos int64  `bson:"photos" json:"photos"`
+	}{}
+	if err := cursor.All(ctx, &byMonth); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute usage"})
+		return
+	}
+
+	albumPipeline := bson.A{
+		bson.M{"$match": bson.M{"user_id": user.ID}},
+		bson.M{"$lookup": bson.M{
+			"from":         database.GetPhotoCollection().Name(),
+			"localField":   "photo_ids",
+			"foreignField": "_id",
+			"as":           "photos",
+			"pipeline":     bson.A{bson.M{"$project": bson.M{"size": 1}}},
+		}},
+		bson.M{"$project": bson.M{
+			"name":   1,
+			"bytes":  bson.M{"$sum": "$photos.size"},
+			"photos": bson.M{"$size": "$photos"},
+		}},
+		bson.M{"$sort": bson.M{"bytes": -1}},
+	}
+	cursor, err = database.GetAlbumCollection().Aggregate(ctx, albumPipeline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute usage"})
+		return
+	}
+	byAlbum := []struct {
+		AlbumID primitive.ObjectID `bson:"_id" json:"album_id"`
+		Name    string             `bson:"name" json:"name"`
+		Bytes   int64              `bson:"bytes" json:"bytes"`
+		Photos  int64              `bson:"photos" json:"photos"`
+	}{}
+	if err := cursor.All(ctx, &byAlbum); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"usage":    user.Usage,
+		"quota":    repository.QuotaFor(user),
+		"by_month": byMonth,
+		"by_album": byAlbum,
+	})
+}
+
+// reserveQuota reserves room for an upload, writing a 413 response if it
+// would take the caller over quota.
+func reserveQuota(c *gin.Context, userID primitive.ObjectID, bytes, photos int64) bool {
+	var user models.User
+	err := database.GetUserCollection().FindOne(context.Background(),
+		bson.M{"_id": userID},
+		options.FindOne().SetProjection(bson.M{"usage": 1, "quota_bytes": 1, "quota_photos": 1}),
+	).Decode(&user)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+		return false
+	}
+
+	quota := repository.QuotaFor(&user)
+	ok, err := repository.ReserveUsage(c.Request.Context(), userID, quota, bytes, photos)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check quota"})
+		return false
+	}
+	if !ok {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":           "storage quota exceeded",
+			"usage":           user.Usage,
+			"quota":           quota,
+			"requested_bytes": bytes,
+		})
+		return false
+	}
+	return true
+}
+
+// releaseQuota gives back the part of a reservation an upload didn't use.
+func releaseQuota(userID primitive.ObjectID, bytes, photos int64) {
+	if err := repository.AddUsage(context.Background(), userID, -bytes, -photos); err != nil {
+		log.Printf("Failed to release quota of user %s: %v", userID.Hex(), err)
+	}
+}
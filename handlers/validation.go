@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+	"golang.org/x/text/unicode/norm"
+)
+
+// The errors a file is rejected with, worded for the client
+var (
+	errUnsupportedType = errors.New("unsupported file type")
+	errCorruptImage    = errors.New("image is corrupt or unreadable")
+	errTooManyPixels   = errors.New("image dimensions are too large")
+)
+
+// allowedImageTypes maps the content types we accept, as sniffed from the
+// first bytes, to the name image.DecodeConfig gives the format.
+var allowedImageTypes = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// Full decodes take as much memory as the image has pixels, so only a few
+// run at a time.
+var decodeSlots = make(chan struct{}, runtime.NumCPU())
+
+// maxImagePixels is the most pixels an image may have, which keeps a small
+// file from decoding into gigabytes.
+func maxImagePixels() int64 {
+	n, err := strconv.ParseInt(os.Getenv("MAX_IMAGE_PIXELS"), 10, 64)
+	if err != nil || n <= 0 {
+		n = 100_000_000
+	}
+	return n
+}
+
+// validateImage checks that f is an image of an allowed type that decodes
+// in full, and returns its content type. The header is checked first, so
+// oversized images are rejected without decoding them.
+func validateImage(f io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", errUnsupportedType
+	}
+	contentType := http.DetectContentType(head[:n])
+	format, ok := allowedImageTypes[contentType]
+	if !ok {
+		return "", errUnsupportedType
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	config, configFormat, err := image.DecodeConfig(f)
+	if err != nil || configFormat != format || config.Width <= 0 || config.Height <= 0 {
+		return "", errCorruptImage
+	}
+	if int64(config.Width)*int64(config.Height) > maxImagePixels() {
+		return "", errTooManyPixels
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	decodeSlots <- struct{}{}
+	_, _, err = image.Decode(f)
+	<-decodeSlots
+	if err != nil {
+		return "", errCorruptImage
+	}
+	return contentType, nil
+}
+
+// Longest file name we keep, in bytes
+const maxFilenameBytes = 255
+
+// sanitizeFilename makes a client-supplied file name safe to store and to
+// hand back in downloads: no directories, no control characters, NFC
+// normalized and of bounded length. Names with nothing usable left become
+// "photo".
+func sanitizeFilename(name string) string {
+	name = strings.ToValidUTF8(name, "")
+	name = strings.ReplaceAll(name, "\\", "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = norm.NFC.String(name)
+
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+
+	if len(name) > maxFilenameBytes {
+		ext := ""
+		if i := strings.LastIndex(name, "."); i > 0 && len(name)-i <= 16 {
+			ext = name[i:]
+		}
+		name = truncateUTF8(name[:len(name)-len(ext)], maxFilenameBytes-len(ext)) + ext
+	}
+
+	if name == "" {
+		return "photo"
+	}
+	return name
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"photo-storage-backend/database"
+	"photo-storage-backend/mailer"
+	"photo-storage-backend/models"
+	"photo-storage-backend/repository"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	verifyEmailTokenTTL   = 48 * time.Hour
+	resetPasswordTokenTTL = time.Hour
+)
+
+// frontendURL is the address of a frontend page.
+func frontendURL(path string) string {
+	base := os.Getenv("APP_URL")
+	if base == "" {
+		base = os.Getenv("FRONTEND_ORIGIN")
+	}
+	if base == "" {
+		base = "http://localhost:5173"
+	}
+	return strings.TrimRight(base, "/") + path
+}
+
+// appURL builds a link to a frontend page carrying a token.
+func appURL(path, token string) string {
+	return frontendURL(path) + "?token=" + url.QueryEscape(token)
+}
+
+func sendVerificationEmail(ctx context.Context, user *models.User) error {
+	token, err := repository.CreateUserToken(ctx, user.ID, models.TokenPurposeVerifyEmail, user.Email, verifyEmailTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	mailer.SendAsync(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening this link:\n\n%s\n\nThe link expires in 48 hours.\n",
+			user.Name, appURL("/verify-email", token)),
+	})
+	return nil
+}
+
+// sendPasswordResetEmail mails a reset link. body says why, with a %s
+// where the link goes.
+func sendPasswordResetEmail(ctx context.Context, user *models.User, body string) error {
+	token, err := repository.CreateUserToken(ctx, user.ID, models.TokenPurposeResetPassword, user.Email, resetPasswordTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	mailer.SendAsync(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf("Hi %s,\n\n"+body+"\n", user.Name, appURL("/reset-password", token)),
+	})
+	return nil
+}
+
+// RequestEmailVerification sends the caller a new verification link.
+func RequestEmailVerification(c *gin.Context) {
+	userIDStr, _ := c.Get("userID")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	var user models.User
+	if err := database.GetUserCollection().FindOne(context.Background(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if user.EmailVerified {
+		c.JSON(http.StatusOK, gin.H{"status": "already verified"})
+		return
+	}
+
+	if err := sendVerificationEmail(c.Request.Context(), &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ConfirmEmailVerification marks the email address a link was sent to as
+// verified, as long as it is still the account's address.
+func ConfirmEmailVerification(c *gin.Context) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+
+	token, err := repository.ConsumeUserToken(c.Request.Context(), body.Token, models.TokenPurposeVerifyEmail)
+	if errors.Is(err, repository.ErrInvalidUserToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+		return
+	}
+
+	res, err := database.GetUserCollection().UpdateOne(context.Background(),
+		bson.M{"_id": token.UserID, "email": token.Email},
+		bson.M{"$set": bson.M{"email_verified": true}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+		return
+	}
+	if res.MatchedCount == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ForgotPassword mails a reset link. It answers the same whether or not the
+// address has an account, so it can't be used to find out who does.
+func ForgotPassword(c *gin.Context) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		return
+	}
+
+	var user models.User
+	err := database.GetUserCollection().FindOne(context.Background(), bson.M{"email": strings.TrimSpace(body.Email)}).Decode(&user)
+	if err == nil {
+		body := "Someone asked to reset the password of your account. If it was you, open this link:\n\n%s\n\nThe link expires in 1 hour. If it wasn't you, you can ignore this email."
+		if err := sendPasswordResetEmail(c.Request.Context(), &user, body); err != nil {
+			log.Printf("Failed to create password reset token: %v", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "if the address has an account, a reset link is on its way"})
+}
+
+// ResetPassword sets a new password with a reset token and logs the
+// account out everywhere.
+func ResetPassword(c *gin.Context) {
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+	if err := validatePassword(body.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := repository.ConsumeUserToken(c.Request.Context(), body.Token, models.TokenPurposeResetPassword)
+	if errors.Is(err, repository.ErrInvalidUserToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+		return
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+		return
+	}
+
+	// The link only works for the address it was sent to, the account may
+	// have moved on from a mailbox someone else now reads
+	var user models.User
+	if err := database.GetUserCollection().FindOne(context.Background(), bson.M{"_id": token.UserID}).Decode(&user); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+	if user.Email != token.Email {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+
+	// Getting the link proves the mailbox is theirs
+	set := bson.M{"password": string(hashed), "password_reset_required": false, "email_verified": true}
+
+	if _, err := database.GetUserCollection().UpdateByID(context.Background(), user.ID, bson.M{"$set": set}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+		return
+	}
+
+	if _, err := repository.RevokeUserSessions(c.Request.Context(), user.ID, nil, "password reset"); err != nil {
+		log.Printf("Failed to revoke sessions after password reset: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
@@ -0,0 +1,244 @@
+// Package lockout slows down password guessing. Failed logins are counted
+// per account (or share link) and per client IP; past a few free attempts each further one
+// doubles the wait before the next try, up to a temporary lockout.
+package lockout
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// State is what a Store knows about one key.
+type State struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// Store keeps the failure counters. Counters are forgotten once window has
+// passed since their last failure.
+type Store interface {
+	Get(ctx context.Context, key string) (State, error)
+	// Reserve counts a failure, but only if the key still has seen
+	// failures, and reports whether it did. This lets callers check and
+	// count in one step.
+	Reserve(ctx context.Context, key string, seen int, now time.Time, window time.Duration) (bool, error)
+	// Forgive takes back one failure, leaving LastFailure alone.
+	Forgive(ctx context.Context, key string) error
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy decides how long to wait after a number of failures.
+type Policy struct {
+	FreeAttempts int           // failures before the first wait
+	BaseDelay    time.Duration // wait after the first counted failure, doubled for each one after
+	MaxDelay     time.Duration // longest wait, the lockout
+	Window       time.Duration // failures older than this are forgotten
+}
+
+// Delay is how long to wait after the given number of failures.
+func (p Policy) Delay(failures int) time.Duration {
+	n := failures - p.FreeAttempts
+	if n < 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := 0; i < n && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// Limiter applies one policy to accounts and a more lenient one to IPs,
+// since many users can share an address.
+type Limiter struct {
+	Store   Store
+	Account Policy
+	IP      Policy
+}
+
+var limiter *Limiter
+
+// Init sets up the limiter from the environment. newMongoStore is only
+// called when the mongo store is selected.
+func Init(newMongoStore func() Store) {
+	lockoutFor, err := time.ParseDuration(os.Getenv("LOGIN_LOCKOUT"))
+	if err != nil || lockoutFor <= 0 {
+		lockoutFor = 15 * time.Minute
+	}
+
+	var store Store
+	switch driver := os.Getenv("LOGIN_LOCKOUT_STORE"); driver {
+	case "", "mongo":
+		store = newMongoStore()
+	case "memory":
+		store = NewMemoryStore()
+	default:
+		log.Fatal("Unknown LOGIN_LOCKOUT_STORE " + driver)
+	}
+
+	limiter = &Limiter{
+		Store: store,
+		Account: Policy{
+			FreeAttempts: envInt("LOGIN_MAX_ATTEMPTS", 5),
+			BaseDelay:    30 * time.Second,
+			MaxDelay:     lockoutFor,
+			Window:       24 * time.Hour,
+		},
+		IP: Policy{
+			FreeAttempts: envInt("LOGIN_IP_MAX_ATTEMPTS", 50),
+			BaseDelay:    30 * time.Second,
+			MaxDelay:     lockoutFor,
+			Window:       time.Hour,
+		},
+	}
+}
+
+func Get() *Limiter {
+	return limiter
+}
+
+func envInt(name string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func shareKey(shareID string) string {
+	return "share:" + shareID
+}
+
+func mfaKey(userID string) string {
+	return "mfa:" + userID
+}
+
+func mfaTokenKey(tokenID string) string {
+	return "mfa_token:" + tokenID
+}
+
+// Attempt lets the caller try to log in to the account from ip, or returns
+// how long they have to wait first. An allowed attempt is counted as a
+// failure right away, so parallel guesses can't all get in before the
+// first of them fails. Succeed takes it back.
+func (l *Limiter) Attempt(ctx context.Context, email, ip string) (time.Duration, error) {
+	return l.attempt(ctx, accountKey(email), ip)
+}
+
+// Succeed clears the account's failures after a login. Of the IP's only
+// this attempt is taken back, or an attacker could reset them by logging
+// into their own account.
+func (l *Limiter) Succeed(ctx context.Context, email, ip string) error {
+	return l.succeed(ctx, accountKey(email), ip)
+}
+
+// AttemptShare is Attempt for the password of a share link, which is
+// limited like an account.
+func (l *Limiter) AttemptShare(ctx context.Context, shareID, ip string) (time.Duration, error) {
+	return l.attempt(ctx, shareKey(shareID), ip)
+}
+
+// SucceedShare is Succeed for the password of a share link.
+func (l *Limiter) SucceedShare(ctx context.Context, shareID, ip string) error {
+	return l.succeed(ctx, shareKey(shareID), ip)
+}
+
+func (l *Limiter) attempt(ctx context.Context, key, ip string) (time.Duration, error) {
+	// The IP goes first, so a locked key doesn't cost the IP anything
+	wait, err := l.reserve(ctx, ipKey(ip), l.IP)
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+	wait, err = l.reserve(ctx, key, l.Account)
+	if err != nil || wait > 0 {
+		if err := l.Store.Forgive(ctx, ipKey(ip)); err != nil {
+			log.Printf("Failed to forgive login attempt: %v", err)
+		}
+	}
+	return wait, err
+}
+
+func (l *Limiter) succeed(ctx context.Context, key, ip string) error {
+	if err := l.Store.Reset(ctx, key); err != nil {
+		return err
+	}
+	return l.Store.Forgive(ctx, ipKey(ip))
+}
+
+// Tries each mfa_token gets at a second factor code
+const mfaTokenAttempts = 5
+
+// ErrTokenUsedUp is returned by AttemptMFA once an mfa_token has had all
+// its tries. The user has to start over with their password.
+var ErrTokenUsedUp = errors.New("no attempts left with this token")
+
+// AttemptMFA lets the user try a second factor code with the mfa_token
+// tokenID, or returns how long they have to wait first. Codes are limited
+// per user like passwords are per account, however many tokens the
+// password step hands out, and each token only gets a few tries. Like
+// Attempt, it counts the try as a failure until SucceedMFA.
+func (l *Limiter) AttemptMFA(ctx context.Context, userID, tokenID string, tokenTTL time.Duration) (time.Duration, error) {
+	// The wait outlasts the token, so a used up token stays used up
+	tokenPolicy := Policy{FreeAttempts: mfaTokenAttempts, BaseDelay: tokenTTL, MaxDelay: tokenTTL, Window: tokenTTL}
+	wait, err := l.reserve(ctx, mfaTokenKey(tokenID), tokenPolicy)
+	if err != nil {
+		return 0, err
+	}
+	if wait > 0 {
+		return 0, ErrTokenUsedUp
+	}
+	wait, err = l.AttemptMFACode(ctx, userID)
+	if err != nil || wait > 0 {
+		if err := l.Store.Forgive(ctx, mfaTokenKey(tokenID)); err != nil {
+			log.Printf("Failed to forgive code attempt: %v", err)
+		}
+	}
+	return wait, err
+}
+
+// AttemptMFACode is AttemptMFA for codes entered by a signed in user,
+// where there is no mfa_token. It shares the per user limit.
+func (l *Limiter) AttemptMFACode(ctx context.Context, userID string) (time.Duration, error) {
+	return l.reserve(ctx, mfaKey(userID), l.Account)
+}
+
+// SucceedMFA clears the user's failed second factor codes.
+func (l *Limiter) SucceedMFA(ctx context.Context, userID string) error {
+	return l.Store.Reset(ctx, mfaKey(userID))
+}
+
+// reserve counts an attempt against key if the policy allows one now, and
+// otherwise returns the wait.
+func (l *Limiter) reserve(ctx context.Context, key string, policy Policy) (time.Duration, error) {
+	for {
+		now := time.Now()
+		state, err := l.Store.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if state.Failures > 0 {
+			if wait := state.LastFailure.Add(policy.Delay(state.Failures)).Sub(now); wait > 0 {
+				return wait, nil
+			}
+		}
+
+		ok, err := l.Store.Reserve(ctx, key, state.Failures, now, policy.Window)
+		if err != nil || ok {
+			return 0, err
+		}
+		// Another attempt got in first, look again
+	}
+}
@@ -0,0 +1,268 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{FreeAttempts: 3, BaseDelay: 30 * time.Second, MaxDelay: 10 * time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, 30 * time.Second},
+		{4, time.Minute},
+		{5, 2 * time.Minute},
+		{7, 8 * time.Minute},
+		{8, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := p.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryStoreReserve(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		prior    int // failures reserved before, in window
+		expired  bool
+		seen     int
+		wantOK   bool
+		wantFail int
+	}{
+		{name: "new key", seen: 0, wantOK: true, wantFail: 1},
+		{name: "matching count", prior: 2, seen: 2, wantOK: true, wantFail: 3},
+		{name: "stale count", prior: 2, seen: 1, wantOK: false, wantFail: 2},
+		{name: "expired counts as none", prior: 4, expired: true, seen: 0, wantOK: true, wantFail: 1},
+		{name: "expired ignores old count", prior: 4, expired: true, seen: 4, wantOK: false, wantFail: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemoryStore()
+			start := now
+			if tt.expired {
+				start = now.Add(-2 * time.Hour)
+			}
+			for i := 0; i < tt.prior; i++ {
+				if ok, _ := s.Reserve(ctx, "k", i, start, time.Hour); !ok {
+					t.Fatalf("setup reserve %d failed", i)
+				}
+			}
+
+			ok, err := s.Reserve(ctx, "k", tt.seen, now, time.Hour)
+			if err != nil || ok != tt.wantOK {
+				t.Fatalf("Reserve = %v, %v, want %v", ok, err, tt.wantOK)
+			}
+			state, _ := s.Get(ctx, "k")
+			if state.Failures != tt.wantFail {
+				t.Errorf("failures = %d, want %d", state.Failures, tt.wantFail)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreForgive(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	s.Reserve(ctx, "k", 0, time.Now(), time.Hour)
+	s.Forgive(ctx, "k")
+	s.Forgive(ctx, "k")
+
+	if state, _ := s.Get(ctx, "k"); state.Failures != 0 {
+		t.Errorf("failures = %d, want 0", state.Failures)
+	}
+}
+
+func newTestLimiter() *Limiter {
+	return &Limiter{
+		Store:   NewMemoryStore(),
+		Account: Policy{FreeAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+		IP:      Policy{FreeAttempts: 5, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+	}
+}
+
+func TestLimiterAttempt(t *testing.T) {
+	type attempt struct {
+		email, ip string
+		succeed   bool // log in after being let in
+		wantWait  bool
+	}
+	a := func(email, ip string, wantWait bool) attempt {
+		return attempt{email: email, ip: ip, wantWait: wantWait}
+	}
+
+	tests := []struct {
+		name     string
+		attempts []attempt
+	}{
+		{
+			name:     "account locks after free attempts",
+			attempts: []attempt{a("a", "1", false), a("a", "1", false), a("a", "1", false), a("a", "1", true)},
+		},
+		{
+			name:     "other IPs don't help",
+			attempts: []attempt{a("a", "1", false), a("a", "2", false), a("a", "3", false), a("a", "4", true)},
+		},
+		{
+			name: "success resets the account",
+			attempts: []attempt{
+				a("a", "1", false), a("a", "1", false),
+				{email: "a", ip: "1", succeed: true},
+				a("a", "1", false), a("a", "1", false), a("a", "1", false), a("a", "1", true),
+			},
+		},
+		{
+			name: "IP locks across accounts",
+			attempts: []attempt{
+				a("a", "1", false), a("b", "1", false), a("c", "1", false),
+				a("d", "1", false), a("e", "1", false), a("f", "1", true),
+			},
+		},
+		{
+			name: "locked account costs the IP nothing",
+			attempts: []attempt{
+				a("a", "1", false), a("a", "1", false), a("a", "1", false),
+				a("a", "1", true), a("a", "1", true), a("a", "1", true),
+				a("b", "1", false), a("c", "1", false),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			l := newTestLimiter()
+			for i, at := range tt.attempts {
+				wait, err := l.Attempt(ctx, at.email, at.ip)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if (wait > 0) != at.wantWait {
+					t.Fatalf("attempt %d: wait = %v, want wait %v", i, wait, at.wantWait)
+				}
+				if at.succeed {
+					if err := l.Succeed(ctx, at.email, at.ip); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterAttemptShare(t *testing.T) {
+	ctx := context.Background()
+	l := newTestLimiter()
+
+	for i := 0; i < l.Account.FreeAttempts; i++ {
+		if wait, err := l.AttemptShare(ctx, "s1", "1"); err != nil || wait > 0 {
+			t.Fatalf("attempt %d: %v, %v", i, wait, err)
+		}
+	}
+	if wait, _ := l.AttemptShare(ctx, "s1", "1"); wait == 0 {
+		t.Fatal("share not limited")
+	}
+
+	// Shares are limited apart from each other and from accounts
+	if wait, _ := l.AttemptShare(ctx, "s2", "2"); wait > 0 {
+		t.Errorf("other share limited: %v", wait)
+	}
+	if wait, _ := l.Attempt(ctx, "s1", "2"); wait > 0 {
+		t.Errorf("account limited: %v", wait)
+	}
+
+	if err := l.SucceedShare(ctx, "s1", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := l.AttemptShare(ctx, "s1", "1"); wait > 0 {
+		t.Errorf("after success: %v", wait)
+	}
+}
+
+func TestLimiterAttemptConcurrent(t *testing.T) {
+	l := newTestLimiter()
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if wait, err := l.Attempt(context.Background(), "a", "1"); err == nil && wait == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got != int32(l.Account.FreeAttempts) {
+		t.Errorf("%d parallel attempts got in, want %d", got, l.Account.FreeAttempts)
+	}
+}
+
+func TestLimiterAttemptMFA(t *testing.T) {
+	ctx := context.Background()
+	ttl := 5 * time.Minute
+
+	t.Run("token is used up", func(t *testing.T) {
+		l := newTestLimiter()
+		l.Account.FreeAttempts = 100
+		for i := 0; i < mfaTokenAttempts; i++ {
+			if wait, err := l.AttemptMFA(ctx, "u", "tok", ttl); err != nil || wait > 0 {
+				t.Fatalf("attempt %d: %v, %v", i, wait, err)
+			}
+		}
+		if _, err := l.AttemptMFA(ctx, "u", "tok", ttl); !errors.Is(err, ErrTokenUsedUp) {
+			t.Fatalf("err = %v, want ErrTokenUsedUp", err)
+		}
+		if wait, err := l.AttemptMFA(ctx, "u", "tok2", ttl); err != nil || wait > 0 {
+			t.Fatalf("new token: %v, %v", wait, err)
+		}
+	})
+
+	t.Run("user is limited across tokens", func(t *testing.T) {
+		l := newTestLimiter()
+		tokens := []string{"t1", "t2", "t3"}
+		for _, tok := range tokens {
+			if wait, err := l.AttemptMFA(ctx, "u", tok, ttl); err != nil || wait > 0 {
+				t.Fatalf("%s: %v, %v", tok, wait, err)
+			}
+		}
+		wait, err := l.AttemptMFA(ctx, "u", "t4", ttl)
+		if err != nil || wait == 0 {
+			t.Fatalf("wait = %v, %v, want a wait", wait, err)
+		}
+
+		// The refused try doesn't count against the token
+		state, _ := l.Store.Get(ctx, mfaTokenKey("t4"))
+		if state.Failures != 0 {
+			t.Errorf("token failures = %d, want 0", state.Failures)
+		}
+	})
+
+	t.Run("success clears the user", func(t *testing.T) {
+		l := newTestLimiter()
+		for i := 0; i < 3; i++ {
+			l.AttemptMFA(ctx, "u", "t", ttl)
+		}
+		if err := l.SucceedMFA(ctx, "u"); err != nil {
+			t.Fatal(err)
+		}
+		if wait, err := l.AttemptMFA(ctx, "u", "t2", ttl); err != nil || wait > 0 {
+			t.Fatalf("after success: %v, %v", wait, err)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps counters in the process. Fine for tests and a single
+// instance, but every instance counts on its own.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	state   State
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]memoryEntry)}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return State{}, nil
+	}
+	return e.state, nil
+}
+
+func (s *MemoryStore) Reserve(ctx context.Context, key string, seen int, now time.Time, window time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || now.After(e.expires) {
+		e = memoryEntry{}
+	}
+	if e.state.Failures != seen {
+		return false, nil
+	}
+	e.state.Failures++
+	e.state.LastFailure = now
+	e.expires = now.Add(window)
+
+	// Drop expired entries now and then so the map doesn't grow forever
+	if len(s.entries) > 10000 {
+		for k, old := range s.entries {
+			if now.After(old.expires) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	s.entries[key] = e
+	return true, nil
+}
+
+func (s *MemoryStore) Forgive(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok && e.state.Failures > 0 {
+		e.state.Failures--
+		s.entries[key] = e
+	}
+	return nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
@@ -0,0 +1,78 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore shares counters between instances. The collection needs a
+// TTL index on expires_at.
+type MongoStore struct {
+	collection *mongo.Collection
+}
+
+type mongoEntry struct {
+	Key         string    `bson:"_id"`
+	Failures    int       `bson:"failures"`
+	LastFailure time.Time `bson:"last_failure"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
+
+func NewMongoStore(collection *mongo.Collection) *MongoStore {
+	return &MongoStore{collection: collection}
+}
+
+func (s *MongoStore) Get(ctx context.Context, key string) (State, error) {
+	var e mongoEntry
+	err := s.collection.FindOne(ctx, bson.M{"_id": key, "expires_at": bson.M{"$gt": time.Now()}}).Decode(&e)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return State{}, nil
+	}
+	if err != nil {
+		return State{}, err
+	}
+	return State{Failures: e.Failures, LastFailure: e.LastFailure}, nil
+}
+
+// Reserve counts the failure in one conditional update. The TTL monitor
+// only runs every minute, so an expired counter counts as none here rather
+// than being trusted. Starting a counter upserts, and loses to a concurrent
+// one on the duplicate _id.
+func (s *MongoStore) Reserve(ctx context.Context, key string, seen int, now time.Time, window time.Duration) (bool, error) {
+	filter := bson.M{"_id": key, "failures": seen, "expires_at": bson.M{"$gt": now}}
+	if seen == 0 {
+		filter = bson.M{"_id": key, "$or": bson.A{
+			bson.M{"failures": bson.M{"$lte": 0}},
+			bson.M{"expires_at": bson.M{"$lte": now}},
+		}}
+	}
+	update := bson.M{"$set": bson.M{
+		"failures":     seen + 1,
+		"last_failure": now,
+		"expires_at":   now.Add(window),
+	}}
+
+	res, err := s.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(seen == 0))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0 || res.UpsertedCount > 0, nil
+}
+
+func (s *MongoStore) Forgive(ctx context.Context, key string) error {
+	_, err := s.collection.UpdateOne(ctx, bson.M{"_id": key, "failures": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"failures": -1}})
+	return err
+}
+
+func (s *MongoStore) Reset(ctx context.Context, key string) error {
+	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
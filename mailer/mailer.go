@@ -0,0 +1,84 @@
+// Package mailer sends the account emails (verification, password reset).
+package mailer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string // plain text
+}
+
+// Mailer delivers messages. Drivers: "smtp" for real delivery (or a local
+// catcher like MailHog) and "log", which only logs, for development.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var mailer Mailer
+
+func InitMailer() {
+	driver := os.Getenv("MAIL_DRIVER")
+	if driver == "" {
+		driver = "log"
+	}
+
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Photo Storage <noreply@localhost>"
+	}
+
+	var err error
+	switch driver {
+	case "log":
+		mailer = LogMailer{}
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "1025"
+		}
+		mailer, err = NewSMTPMailer(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		})
+	default:
+		err = errors.New("unknown MAIL_DRIVER " + driver)
+	}
+	if err != nil {
+		log.Fatal("Mailer init failed:", err)
+	}
+}
+
+func GetMailer() Mailer {
+	return mailer
+}
+
+// SendAsync sends msg in the background so requests don't wait on the mail
+// server. Failures are only logged.
+func SendAsync(msg Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := mailer.Send(ctx, msg); err != nil {
+			log.Printf("Failed to send %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
+
+// LogMailer writes messages to the log instead of sending them.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
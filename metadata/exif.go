@@ -0,0 +1,105 @@
+// Package metadata reads capture details (time, camera, exposure, location)
+// from uploaded photos.
+package metadata
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	"photo-storage-backend/models"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// Extract parses the EXIF block of a JPEG or TIFF. It returns nil when the
+// file has no usable EXIF, which is normal for PNGs, screenshots and most
+// images that went through a messenger app.
+func Extract(r io.Reader) *models.PhotoMetadata {
+	x, err := exif.Decode(r)
+	if err != nil && (x == nil || exif.IsCriticalError(err)) {
+		return nil
+	}
+
+	meta := &models.PhotoMetadata{
+		CameraMake:   stringTag(x, exif.Make),
+		CameraModel:  stringTag(x, exif.Model),
+		LensModel:    stringTag(x, exif.LensModel),
+		ExposureTime: ratString(x, exif.ExposureTime),
+		FNumber:      ratFloat(x, exif.FNumber),
+		ISO:          intTag(x, exif.ISOSpeedRatings),
+		FocalLength:  ratFloat(x, exif.FocalLength),
+		Orientation:  intTag(x, exif.Orientation),
+	}
+
+	// Cameras store local wall-clock time without a zone. We keep it as if
+	// it were UTC so the timeline shows the time printed on the camera.
+	for _, name := range []exif.FieldName{exif.DateTimeOriginal, exif.DateTimeDigitized, exif.DateTime} {
+		if s := stringTag(x, name); s != "" {
+			if t, err := time.ParseInLocation(exifTimeLayout, s, time.UTC); err == nil && t.Year() > 1900 {
+				meta.TakenAt = t.Unix()
+				break
+			}
+		}
+	}
+
+	if lat, lng, err := x.LatLong(); err == nil && !(lat == 0 && lng == 0) {
+		meta.GPS = &models.GPSLocation{Latitude: lat, Longitude: lng}
+		if alt := ratFloat(x, exif.GPSAltitude); alt != 0 {
+			if intTag(x, exif.GPSAltitudeRef) == 1 {
+				alt = -alt
+			}
+			meta.GPS.Altitude = alt
+		}
+	}
+
+	if *meta == (models.PhotoMetadata{}) {
+		return nil
+	}
+	return meta
+}
+
+func stringTag(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil || tag.Format() != tiff.StringVal {
+		return ""
+	}
+	s, _ := tag.StringVal()
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
+
+func intTag(x *exif.Exif, name exif.FieldName) int {
+	tag, err := x.Get(name)
+	if err != nil || tag.Format() != tiff.IntVal {
+		return 0
+	}
+	v, _ := tag.Int(0)
+	return v
+}
+
+func ratFloat(x *exif.Exif, name exif.FieldName) float64 {
+	tag, err := x.Get(name)
+	if err != nil || tag.Format() != tiff.RatVal {
+		return 0
+	}
+	num, den, err := tag.Rat2(0)
+	if err != nil || den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
+
+func ratString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil || tag.Format() != tiff.RatVal {
+		return ""
+	}
+	r, err := tag.Rat(0)
+	if err != nil {
+		return ""
+	}
+	return r.RatString()
+}
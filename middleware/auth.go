@@ -1,27 +1,106 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
+	"photo-storage-backend/models"
+	"photo-storage-backend/repository"
 	"photo-storage-backend/utils"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenStr := c.GetHeader("Authorization")
-		if tokenStr == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
+		if !authenticate(c) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// SignedURLOrAuth accepts either a normal token or a URL signed with
+// utils.SignURL, for endpoints that browsers load directly (<img src>).
+func SignedURLOrAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Query("sig") == "" {
+			if !authenticate(c) {
+				return
+			}
+			c.Next()
 			return
 		}
 
-		userID, err := utils.ParseToken(tokenStr)
+		userID, err := utils.VerifySignedURL(c.Request.URL.Path, c.Request.URL.Query())
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired link"})
 			return
 		}
 
-		c.Set("userID", userID) // simpan di context
+		c.Set("userID", userID)
 		c.Next()
 	}
 }
+
+// authenticate checks the Authorization header and stores the user ID in
+// the context. It aborts the request and returns false if the token is bad.
+func authenticate(c *gin.Context) bool {
+	tokenStr := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if tokenStr == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
+		return false
+	}
+
+	if strings.HasPrefix(tokenStr, models.AccessTokenPrefix) {
+		return authenticateAccessToken(c, tokenStr)
+	}
+
+	claims, err := utils.ParseToken(tokenStr)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return false
+	}
+
+	// Tokens from before sessions existed have no sid and simply run out
+	if claims.SessionID != "" {
+		sessionID, err := primitive.ObjectIDFromHex(claims.SessionID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			return false
+		}
+		active, err := repository.SessionActive(c.Request.Context(), sessionID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to check session"})
+			return false
+		}
+		if !active {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
+			return false
+		}
+		repository.TouchSession(sessionID, c.ClientIP())
+		c.Set("sessionID", claims.SessionID)
+	}
+
+	c.Set("userID", claims.UserID) // simpan di context
+	return true
+}
+
+// authenticateAccessToken accepts a personal access token. Its scopes go
+// into the context for RequireScope.
+func authenticateAccessToken(c *gin.Context, tokenStr string) bool {
+	token, err := repository.LookupAccessToken(c.Request.Context(), tokenStr)
+	if errors.Is(err, repository.ErrInvalidAccessToken) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return false
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to check token"})
+		return false
+	}
+
+	c.Set("tokenScopes", token.Scopes)
+	c.Set("userID", token.UserID.Hex())
+	return true
+}
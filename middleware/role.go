@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RequireRole limits a route to users with the role. Goes after
+// AuthMiddleware. The role is read on every request, so taking it away
+// works right away.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDStr, _ := c.Get("userID")
+		userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+		var user models.User
+		err := database.GetUserCollection().FindOne(context.Background(),
+			bson.M{"_id": userID},
+			options.FindOne().SetProjection(bson.M{"role": 1, "disabled_at": 1}),
+		).Decode(&user)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+		if user.Disabled() || user.UserRole() != role {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not allowed for your role"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RequireVerifiedEmail blocks accounts that haven't confirmed their email
+// address, when REQUIRE_VERIFIED_EMAIL=true. Goes after AuthMiddleware.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if os.Getenv("REQUIRE_VERIFIED_EMAIL") != "true" {
+			c.Next()
+			return
+		}
+
+		userIDStr, _ := c.Get("userID")
+		userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+		var user models.User
+		err := database.GetUserCollection().FindOne(context.Background(),
+			bson.M{"_id": userID},
+			options.FindOne().SetProjection(bson.M{"email_verified": 1}),
+		).Decode(&user)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+		if !user.EmailVerified {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "email not verified", "email_verified": false})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,33 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Scopes a personal access token can be given
+const (
+	ScopePhotosRead  = "photos:read"
+	ScopePhotosWrite = "photos:write"
+	ScopeSearch      = "search"
+	ScopeAlbumsRead  = "albums:read"
+	ScopeAlbumsWrite = "albums:write"
+)
+
+var AccessTokenScopes = []string{ScopePhotosRead, ScopePhotosWrite, ScopeSearch, ScopeAlbumsRead, ScopeAlbumsWrite}
+
+// AccessTokenPrefix marks personal access tokens, so they are easy to tell
+// apart from JWTs and to spot in leaked code.
+const AccessTokenPrefix = "psp_"
+
+// AccessToken is a personal access token for scripts and sync clients.
+// Only its hash is stored; Prefix is kept so users can tell tokens apart.
+type AccessToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"-"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"`
+	TokenHash  string             `bson:"token_hash" json:"-"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	CreatedAt  int64              `bson:"created_at" json:"created_at"`
+	LastUsedAt int64              `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	ExpiresAt  int64              `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // 0 means never
+	RevokedAt  int64              `bson:"revoked_at,omitempty" json:"-"`
+}
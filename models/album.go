@@ -0,0 +1,42 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Album roles, each one can do everything the previous one can.
+// Viewers see the album, contributors also add their own photos to it,
+// editors also remove and reorder photos and change its details. Deleting
+// the album and managing members is left to the owner.
+const (
+	AlbumRoleViewer      = "viewer"
+	AlbumRoleContributor = "contributor"
+	AlbumRoleEditor      = "editor"
+	AlbumRoleOwner       = "owner"
+)
+
+type Album struct {
+	ID           primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	UserID       primitive.ObjectID   `bson:"user_id" json:"-"`
+	Name         string               `bson:"name" json:"name"`
+	Description  string               `bson:"description,omitempty" json:"description,omitempty"`
+	CoverPhotoID *primitive.ObjectID  `bson:"cover_photo_id,omitempty" json:"cover_photo_id,omitempty"`
+	PhotoIDs     []primitive.ObjectID `bson:"photo_ids" json:"-"` // in display order
+	Members      []AlbumMember        `bson:"members,omitempty" json:"members,omitempty"`
+	CreatedAt    int64                `bson:"created_at" json:"created_at"`
+	UpdatedAt    int64                `bson:"updated_at" json:"updated_at"`
+
+	// Filled in by handlers for responses
+	PhotoCount int    `bson:"-" json:"photo_count"`
+	CoverURL   string `bson:"-" json:"cover_url,omitempty"`
+	Role       string `bson:"-" json:"role,omitempty"` // the caller's role
+	OwnerEmail string `bson:"-" json:"owner_email,omitempty"`
+}
+
+// AlbumMember is another account the album is shared with.
+type AlbumMember struct {
+	UserID  primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Email   string             `bson:"email" json:"email"`
+	Role    string             `bson:"role" json:"role"`
+	AddedAt int64              `bson:"added_at" json:"added_at"`
+}
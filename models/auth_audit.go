@@ -0,0 +1,22 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Auth audit events
+const (
+	AuthEventLoginFailed = "login_failed"
+	AuthEventLoginLocked = "login_locked"
+)
+
+// AuthAuditEvent records a failed or refused login. UserID is empty when
+// the email doesn't belong to an account.
+type AuthAuditEvent struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Event     string              `bson:"event" json:"event"`
+	Email     string              `bson:"email" json:"email"`
+	UserID    *primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Reason    string              `bson:"reason,omitempty" json:"reason,omitempty"`
+	IP        string              `bson:"ip" json:"ip"`
+	UserAgent string              `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	CreatedAt int64               `bson:"created_at" json:"created_at"`
+}
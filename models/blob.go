@@ -0,0 +1,14 @@
+package models
+
+// Blob tracks how many photos reference a content-addressed object in
+// storage. The object is only removed once RefCount drops to zero.
+type Blob struct {
+	Digest      string `bson:"_id" json:"digest"`
+	Key         string `bson:"key" json:"key"`
+	Size        int64  `bson:"size" json:"size"`
+	ContentType string `bson:"content_type" json:"content_type"`
+	RefCount    int    `bson:"ref_count" json:"ref_count"`
+	CreatedAt   int64  `bson:"created_at" json:"created_at"`
+	// Set while the object is being deleted, the record goes once it is
+	DeletingAt int64 `bson:"deleting_at,omitempty" json:"deleting_at,omitempty"`
+}
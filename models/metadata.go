@@ -0,0 +1,22 @@
+package models
+
+// PhotoMetadata is what we could read from a photo's EXIF block. Every field
+// is optional, cameras and editing tools leave out whatever they like.
+type PhotoMetadata struct {
+	TakenAt      int64        `bson:"taken_at,omitempty" json:"taken_at,omitempty"` // unix seconds, camera clock read as UTC
+	CameraMake   string       `bson:"camera_make,omitempty" json:"camera_make,omitempty"`
+	CameraModel  string       `bson:"camera_model,omitempty" json:"camera_model,omitempty"`
+	LensModel    string       `bson:"lens_model,omitempty" json:"lens_model,omitempty"`
+	ExposureTime string       `bson:"exposure_time,omitempty" json:"exposure_time,omitempty"` // e.g. "1/250"
+	FNumber      float64      `bson:"f_number,omitempty" json:"f_number,omitempty"`
+	ISO          int          `bson:"iso,omitempty" json:"iso,omitempty"`
+	FocalLength  float64      `bson:"focal_length,omitempty" json:"focal_length,omitempty"` // mm
+	Orientation  int          `bson:"orientation,omitempty" json:"orientation,omitempty"`   // EXIF 1-8
+	GPS          *GPSLocation `bson:"gps,omitempty" json:"gps,omitempty"`
+}
+
+type GPSLocation struct {
+	Latitude  float64 `bson:"latitude" json:"latitude"`
+	Longitude float64 `bson:"longitude" json:"longitude"`
+	Altitude  float64 `bson:"altitude,omitempty" json:"altitude,omitempty"` // meters
+}
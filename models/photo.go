@@ -7,9 +7,30 @@ import (
 type Photo struct {
 	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	Name     string             `bson:"name" json:"name"`
-	Path     string             `bson:"path" json:"path"`
+	Path     string             `bson:"path" json:"path"` // storage key, see storage.BlobStore
 	UploadAt int64              `bson:"upload_at" json:"upload_at"`
 	UserID   primitive.ObjectID `bson:"user_id" json:"-"`
 	Embedded bool               `bson:"embedded" json:"embedded"`
 	BatchID  primitive.ObjectID `bson:"batch_id" json:"batch_id"`
+
+	Hash        string `bson:"hash,omitempty" json:"hash,omitempty"` // SHA-256 hex of the content
+	Size        int64  `bson:"size" json:"size"`
+	ContentType string `bson:"content_type,omitempty" json:"content_type,omitempty"`
+
+	Renditions []Rendition `bson:"renditions,omitempty" json:"renditions,omitempty"`
+
+	// TakenAt is the EXIF capture time, or UploadAt when the photo has none,
+	// so the timeline can always sort on it.
+	TakenAt  int64          `bson:"taken_at" json:"taken_at"`
+	Metadata *PhotoMetadata `bson:"metadata,omitempty" json:"metadata,omitempty"`
+
+	Tags     []string `bson:"tags,omitempty" json:"tags,omitempty"` // normalized, lower case
+	Favorite bool     `bson:"favorite" json:"favorite"`
+
+	// DeletedAt is set while the photo is in the trash
+	DeletedAt int64 `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+
+	// URLs are filled in by handlers for responses, they are never stored.
+	URL          string `bson:"-" json:"url,omitempty"`
+	ThumbnailURL string `bson:"-" json:"thumbnail_url,omitempty"`
 }
@@ -0,0 +1,9 @@
+package models
+
+// Rendition is a resized JPEG copy of a photo, stored next to the original.
+type Rendition struct {
+	Size   int    `bson:"size" json:"size"` // bounding box edge in pixels
+	Key    string `bson:"key" json:"-"`
+	Width  int    `bson:"width" json:"width"`
+	Height int    `bson:"height" json:"height"`
+}
@@ -7,4 +7,9 @@ type InferenceSearchResult struct {
 	UploadAt int64   `json:"upload_at"`
 	Path     string  `json:"path"`
 	Score    float64 `json:"score"`
+
+	// Filled in from our own photo documents
+	PhotoID      string `json:"photo_id,omitempty"`
+	URL          string `json:"url,omitempty"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
 }
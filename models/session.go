@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is one login. Its refresh tokens form a family: each refresh
+// replaces the previous token, and revoking the session ends them all.
+type Session struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID        primitive.ObjectID `bson:"user_id" json:"-"`
+	CreatedAt     int64              `bson:"created_at" json:"created_at"`
+	ExpiresAt     int64              `bson:"expires_at" json:"expires_at"` // of the latest refresh token
+	LastSeenAt    int64              `bson:"last_seen_at" json:"last_seen_at"`
+	UserAgent     string             `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	IP            string             `bson:"ip,omitempty" json:"ip,omitempty"`
+	RevokedAt     int64              `bson:"revoked_at,omitempty" json:"-"`
+	RevokedReason string             `bson:"revoked_reason,omitempty" json:"-"`
+
+	// Filled in by handlers for responses
+	Current bool `bson:"-" json:"current"`
+}
+
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	SessionID primitive.ObjectID `bson:"session_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	TokenHash string             `bson:"token_hash"`
+	CreatedAt int64              `bson:"created_at"`
+	UsedAt    int64              `bson:"used_at,omitempty"`
+
+	// A date rather than unix seconds so a TTL index can remove old tokens
+	ExpiresAt time.Time `bson:"expires_at"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Share is a public link to a single photo or an album.
+type Share struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID        primitive.ObjectID  `bson:"user_id" json:"-"`
+	Token         string              `bson:"token" json:"token"`
+	Kind          string              `bson:"kind" json:"kind"` // "photo" or "album"
+	PhotoID       *primitive.ObjectID `bson:"photo_id,omitempty" json:"photo_id,omitempty"`
+	AlbumID       *primitive.ObjectID `bson:"album_id,omitempty" json:"album_id,omitempty"`
+	ExpiresAt     int64               `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	PasswordHash  string              `bson:"password_hash,omitempty" json:"-"`
+	AllowDownload bool                `bson:"allow_download" json:"allow_download"`
+	CreatedAt     int64               `bson:"created_at" json:"created_at"`
+	RevokedAt     int64               `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	Views         int64               `bson:"views" json:"views"`
+
+	// Filled in by handlers for responses
+	HasPassword bool   `bson:"-" json:"has_password"`
+	URL         string `bson:"-" json:"url,omitempty"`
+}
+
+const (
+	ShareKindPhoto = "photo"
+	ShareKindAlbum = "album"
+)
@@ -0,0 +1,33 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Upload states
+const (
+	UploadStatusUploading  = "uploading"
+	UploadStatusProcessing = "processing" // all bytes are in, becoming a photo
+	UploadStatusCompleted  = "completed"
+	UploadStatusDuplicate  = "duplicate"
+	UploadStatusFailed     = "failed"
+)
+
+// Upload is a resumable (tus) upload of one file. Its bytes collect in a
+// temp file until Offset reaches Length, then it becomes a photo. Finished
+// uploads are kept until they expire so clients can look up the result.
+type Upload struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID      primitive.ObjectID  `bson:"user_id" json:"-"`
+	BatchID     primitive.ObjectID  `bson:"batch_id" json:"batch_id"`
+	AlbumID     *primitive.ObjectID `bson:"album_id,omitempty" json:"album_id,omitempty"`
+	Filename    string              `bson:"filename" json:"filename"`
+	ContentType string              `bson:"content_type,omitempty" json:"content_type,omitempty"`
+	SHA256      string              `bson:"sha256,omitempty" json:"sha256,omitempty"`
+	Length      int64               `bson:"length" json:"length"`
+	Offset      int64               `bson:"offset" json:"offset"`
+	CreatedAt   int64               `bson:"created_at" json:"created_at"`
+	ExpiresAt   int64               `bson:"expires_at" json:"expires_at"`
+
+	Status  string              `bson:"status" json:"status"`
+	PhotoID *primitive.ObjectID `bson:"photo_id,omitempty" json:"photo_id,omitempty"`
+	Error   string              `bson:"error,omitempty" json:"error,omitempty"`
+}
@@ -2,9 +2,83 @@ package models
 
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
+// User roles. Users without a role are plain users.
+const (
+	UserRoleUser  = "user"
+	UserRoleAdmin = "admin"
+)
+
 type User struct {
 	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	Name     string             `bson:"name" json:"name"`
 	Email    string             `bson:"email" json:"email"`
-	Password string             `bson:"password" json:"password"`
+	Password string             `bson:"password" json:"-"`
+
+	EmailVerified bool           `bson:"email_verified" json:"email_verified"`
+	Identities    []UserIdentity `bson:"identities,omitempty" json:"identities,omitempty"`
+	MFA           *UserMFA       `bson:"mfa,omitempty" json:"-"`
+
+	// Usage counts the user's photos, trashed ones included since they
+	// still take up space. Quotas of 0 use the QUOTA_* defaults, -1 means
+	// no limit.
+	Usage       UserUsage `bson:"usage" json:"usage"`
+	QuotaBytes  int64     `bson:"quota_bytes,omitempty" json:"quota_bytes,omitempty"`
+	QuotaPhotos int64     `bson:"quota_photos,omitempty" json:"quota_photos,omitempty"`
+
+	Role       string `bson:"role,omitempty" json:"role"`
+	DisabledAt int64  `bson:"disabled_at,omitempty" json:"disabled_at,omitempty"`
+
+	// DeletedAt is set when the user deletes their account. The document
+	// stays, without anything to sign in with, until cleanup has removed
+	// the rest of the account's data.
+	DeletedAt int64 `bson:"deleted_at,omitempty" json:"-"`
+
+	// PasswordResetRequired is set by an admin; the password stops working
+	// for login until it is reset by email
+	PasswordResetRequired bool `bson:"password_reset_required,omitempty" json:"password_reset_required,omitempty"`
+
+	// Filled in by handlers for responses
+	HasPassword bool `bson:"-" json:"has_password"`
+	TwoFactor   bool `bson:"-" json:"mfa_enabled"`
+}
+
+// UserMFA is the TOTP second factor. PendingSecret holds a secret between
+// enrollment and confirmation; Secret is only set once confirmed.
+type UserMFA struct {
+	Secret        string   `bson:"secret,omitempty"`
+	PendingSecret string   `bson:"pending_secret,omitempty"`
+	RecoveryCodes []string `bson:"recovery_codes,omitempty"` // hashed, each works once
+	LastStep      int64    `bson:"last_step"`                // last accepted time step, against replays
+	EnabledAt     int64    `bson:"enabled_at,omitempty"`
+}
+
+// UserRole returns the user's role, UserRoleUser if none is set.
+func (u *User) UserRole() string {
+	if u.Role == "" {
+		return UserRoleUser
+	}
+	return u.Role
+}
+
+// Disabled reports whether an admin disabled the account.
+func (u *User) Disabled() bool {
+	return u.DisabledAt != 0
+}
+
+// MFAEnabled reports whether the user has to enter a second factor.
+func (u *User) MFAEnabled() bool {
+	return u.MFA != nil && u.MFA.Secret != ""
+}
+
+// UserIdentity links an account at an external OpenID Connect provider.
+type UserIdentity struct {
+	Provider string `bson:"provider" json:"provider"`
+	Subject  string `bson:"subject" json:"-"`
+	Email    string `bson:"email,omitempty" json:"email,omitempty"`
+	LinkedAt int64  `bson:"linked_at" json:"linked_at"`
+}
+
+type UserUsage struct {
+	Bytes  int64 `bson:"bytes" json:"bytes"`
+	Photos int64 `bson:"photos" json:"photos"`
 }
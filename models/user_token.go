@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Purposes of a UserToken
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+)
+
+// UserToken is a single-use token mailed to a user, e.g. in a password
+// reset link. Only its hash is stored.
+type UserToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Purpose   string             `bson:"purpose"`
+	Email     string             `bson:"email"` // address the token was sent to
+	TokenHash string             `bson:"token_hash"`
+	CreatedAt int64              `bson:"created_at"`
+	UsedAt    int64              `bson:"used_at,omitempty"`
+
+	// A date rather than unix seconds so a TTL index can remove old tokens
+	ExpiresAt time.Time `bson:"expires_at"`
+}
@@ -0,0 +1,225 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIssuer is a provider serving discovery, a key set and a token
+// endpoint that hands out whatever idToken is set to.
+type mockIssuer struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	idToken string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "authorization_code" || r.Form.Get("code") != "the-code" || r.Form.Get("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": m.idToken})
+	})
+
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockIssuer) provider() *Provider {
+	return &Provider{
+		Name:        "mock",
+		Issuer:      m.URL,
+		ClientID:    "client",
+		RedirectURL: "https://app.example/callback",
+		Scopes:      []string{"openid", "email"},
+	}
+}
+
+func (m *mockIssuer) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	raw, err := token.SignedString(m.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func (m *mockIssuer) claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            m.URL,
+		"aud":            "client",
+		"sub":            "user-1",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"nonce":          "the-nonce",
+		"email":          "a@example.com",
+		"email_verified": true,
+		"name":           "A",
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	m := newMockIssuer(t)
+
+	raw, err := m.provider().AuthCodeURL(context.Background(), "the-state", "the-nonce", "the-challenge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != m.URL+"/authorize" {
+		t.Errorf("endpoint = %s", got)
+	}
+	q := u.Query()
+	for k, want := range map[string]string{
+		"client_id":             "client",
+		"state":                 "the-state",
+		"nonce":                 "the-nonce",
+		"code_challenge":        "the-challenge",
+		"code_challenge_method": "S256",
+		"scope":                 "openid email",
+	} {
+		if q.Get(k) != want {
+			t.Errorf("%s = %q, want %q", k, q.Get(k), want)
+		}
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider()
+	p.Issuer = strings.Replace(m.URL, "127.0.0.1", "localhost", 1)
+
+	if _, err := p.AuthCodeURL(context.Background(), "s", "n", "c"); err == nil {
+		t.Fatal("accepted discovery document of another issuer")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockIssuer(t)
+
+	with := func(changes jwt.MapClaims) jwt.MapClaims {
+		claims := m.claims()
+		for k, v := range changes {
+			if v == nil {
+				delete(claims, k)
+			} else {
+				claims[k] = v
+			}
+		}
+		return claims
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodRS256, m.claims()).SignedString(other)
+
+	tests := []struct {
+		name    string
+		token   string
+		code    string
+		want    *Identity
+		wantErr bool
+	}{
+		{
+			name:  "valid",
+			token: m.sign(t, "k1", m.claims()),
+			want:  &Identity{Subject: "user-1", Email: "a@example.com", EmailVerified: true, Name: "A"},
+		},
+		{
+			name:  "single key without kid",
+			token: m.sign(t, "", m.claims()),
+			want:  &Identity{Subject: "user-1", Email: "a@example.com", EmailVerified: true, Name: "A"},
+		},
+		{
+			name:  "email_verified as a string",
+			token: m.sign(t, "k1", with(jwt.MapClaims{"email_verified": "true"})),
+			want:  &Identity{Subject: "user-1", Email: "a@example.com", EmailVerified: true, Name: "A"},
+		},
+		{
+			name:  "unverified email",
+			token: m.sign(t, "k1", with(jwt.MapClaims{"email_verified": false})),
+			want:  &Identity{Subject: "user-1", Email: "a@example.com", Name: "A"},
+		},
+		{name: "wrong nonce", token: m.sign(t, "k1", with(jwt.MapClaims{"nonce": "other"})), wantErr: true},
+		{name: "no nonce", token: m.sign(t, "k1", with(jwt.MapClaims{"nonce": nil})), wantErr: true},
+		{name: "other audience", token: m.sign(t, "k1", with(jwt.MapClaims{"aud": "someone-else"})), wantErr: true},
+		{name: "other issuer", token: m.sign(t, "k1", with(jwt.MapClaims{"iss": "https://evil.example"})), wantErr: true},
+		{name: "expired", token: m.sign(t, "k1", with(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})), wantErr: true},
+		{name: "no expiry", token: m.sign(t, "k1", with(jwt.MapClaims{"exp": nil})), wantErr: true},
+		{name: "no subject", token: m.sign(t, "k1", with(jwt.MapClaims{"sub": nil})), wantErr: true},
+		{name: "unknown kid", token: m.sign(t, "k2", m.claims()), wantErr: true},
+		{name: "signed by another key", token: forged, wantErr: true},
+		{name: "code refused", token: m.sign(t, "k1", m.claims()), code: "bad-code", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.idToken = tt.token
+			code := tt.code
+			if code == "" {
+				code = "the-code"
+			}
+
+			got, err := m.provider().Exchange(context.Background(), code, "the-verifier", "the-nonce")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("accepted, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != *tt.want {
+				t.Errorf("identity = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+// Package renditions generates the resized copies (thumbnails, previews) the
+// gallery loads instead of full originals.
+package renditions
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"photo-storage-backend/models"
+	"photo-storage-backend/repository"
+	"photo-storage-backend/storage"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Sizes are the bounding boxes renditions are generated for, smallest first.
+var Sizes = []int{256, 1024}
+
+const jpegQuality = 85
+
+// Key is where the rendition of the object at key is stored.
+func Key(key string, size int) string {
+	return fmt.Sprintf("%s_%d.jpg", key, size)
+}
+
+// Keys lists every rendition key for the object at key.
+func Keys(key string) []string {
+	keys := make([]string, len(Sizes))
+	for i, size := range Sizes {
+		keys[i] = Key(key, size)
+	}
+	return keys
+}
+
+// Pick returns the smallest configured size that covers the requested one,
+// or the largest size if none does.
+func Pick(requested int) int {
+	for _, size := range Sizes {
+		if size >= requested {
+			return size
+		}
+	}
+	return Sizes[len(Sizes)-1]
+}
+
+// Find returns the photo's rendition for size, if it has one.
+func Find(photo models.Photo, size int) (models.Rendition, bool) {
+	for _, r := range photo.Renditions {
+		if r.Size == size {
+			return r, true
+		}
+	}
+	return models.Rendition{}, false
+}
+
+var queue chan models.Photo
+
+// Start runs workers that generate renditions for photos passed to Enqueue.
+func Start(workers int) {
+	queue = make(chan models.Photo, 1000)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for photo := range queue {
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+				if _, err := Ensure(ctx, photo); err != nil {
+					log.Printf("Failed to generate renditions for %s: %v", photo.ID.Hex(), err)
+				}
+				cancel()
+			}
+		}()
+	}
+	log.Printf("Rendition workers started (%d)", workers)
+}
+
+// Enqueue schedules background rendition generation. When the queue is full
+// the photo is skipped, the thumbnail endpoint will generate it on demand.
+func Enqueue(photo models.Photo) {
+	if queue == nil {
+		return
+	}
+	select {
+	case queue <- photo:
+	default:
+		log.Printf("Rendition queue full, skipping %s", photo.ID.Hex())
+	}
+}
+
+// Ensure makes sure every configured rendition of the photo exists in
+// storage and is recorded on the photo documents, and returns them.
+// Concurrent calls for the same original share one generation run.
+func Ensure(ctx context.Context, photo models.Photo) ([]models.Rendition, error) {
+	if len(photo.Renditions) == len(Sizes) {
+		return photo.Renditions, nil
+	}
+	return inflight.do(photo.Path, func() ([]models.Rendition, error) {
+		return generate(ctx, photo)
+	})
+}
+
+func generate(ctx context.Context, photo models.Photo) ([]models.Rendition, error) {
+	store := storage.GetStore()
+
+	src, _, err := store.Get(ctx, photo.Path)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(src)
+	src.Close()
+	if err != nil {
+		return nil, fmt.Errorf("decode original: %w", err)
+	}
+
+	orientation := 1
+	if photo.Metadata != nil {
+		orientation = photo.Metadata.Orientation
+	}
+
+	var result []models.Rendition
+	for _, size := range Sizes {
+		resized := orient(fit(img, size), orientation)
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, resized, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, err
+		}
+
+		key := Key(photo.Path, size)
+		if err := store.Put(ctx, key, &buf, int64(buf.Len()), "image/jpeg"); err != nil {
+			return nil, err
+		}
+
+		b := resized.Bounds()
+		result = append(result, models.Rendition{Size: size, Key: key, Width: b.Dx(), Height: b.Dy()})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Size < result[j].Size })
+	if err := repository.SetRenditions(ctx, photo.Path, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// fit scales img down to fit a size x size box, keeping the aspect ratio.
+// Images that already fit are copied as is, never upscaled. Transparent
+// areas are flattened onto white since the output is JPEG.
+func fit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			h = max(1, h*size/w)
+			w = size
+		} else {
+			w = max(1, w*size/h)
+			h = size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	if w == b.Dx() && h == b.Dy() {
+		draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	} else {
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	}
+	return dst
+}
+
+// orient applies an EXIF orientation (1-8) so renditions display upright
+// without the browser having to read EXIF, which our JPEG output drops.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored upside down
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // needs 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // needs 90° counter-clockwise
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// group collapses concurrent generation runs for the same original.
+type group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	wg  sync.WaitGroup
+	res []models.Rendition
+	err error
+}
+
+var inflight = &group{calls: make(map[string]*call)}
+
+var errPanicked = errors.New("rendition generation panicked")
+
+func (g *group) do(key string, fn func() ([]models.Rendition, error)) ([]models.Rendition, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.res, c.err
+	}
+	c := &call{err: errPanicked}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+
+	c.res, c.err = fn()
+	return c.res, c.err
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+	"photo-storage-backend/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrInvalidAccessToken = errors.New("invalid access token")
+
+// LookupAccessToken finds the active personal access token for a raw
+// token. Results are cached briefly like sessions, and last-used times are
+// written at most every few minutes.
+func LookupAccessToken(ctx context.Context, token string) (*models.AccessToken, error) {
+	hash := utils.HashToken(token)
+
+	at, ok := accessTokenCache.get(hash)
+	if !ok {
+		var doc models.AccessToken
+		err := database.GetAccessTokenCollection().FindOne(ctx, bson.M{"token_hash": hash}).Decode(&doc)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidAccessToken
+		}
+		if err != nil {
+			return nil, err
+		}
+		at = &doc
+		accessTokenCache.set(hash, at)
+	}
+
+	if at.RevokedAt != 0 || (at.ExpiresAt != 0 && time.Now().Unix() > at.ExpiresAt) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	now := time.Now()
+	if lastSeen.due(at.ID, now) {
+		go func(id primitive.ObjectID) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := database.GetAccessTokenCollection().UpdateByID(ctx, id, bson.M{"$set": bson.M{"last_used_at": now.Unix()}}); err != nil {
+				log.Printf("Failed to update access token last used: %v", err)
+			}
+		}(at.ID)
+	}
+	return at, nil
+}
+
+// RevokeAccessToken revokes one of the user's tokens and reports whether
+// there was one to revoke.
+func RevokeAccessToken(ctx context.Context, userID, tokenID primitive.ObjectID) (bool, error) {
+	var doc models.AccessToken
+	err := database.GetAccessTokenCollection().FindOneAndUpdate(ctx,
+		bson.M{"_id": tokenID, "user_id": userID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().Unix()}},
+	).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	accessTokenCache.delete(doc.TokenHash)
+	return true, nil
+}
+
+// RevokeUserAccessTokens revokes all of the user's tokens.
+func RevokeUserAccessTokens(ctx context.Context, userID primitive.ObjectID) error {
+	collection := database.GetAccessTokenCollection()
+	filter := bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}}
+
+	hashes, err := collection.Distinct(ctx, "token_hash", filter)
+	if err != nil {
+		return err
+	}
+	if _, err := collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": time.Now().Unix()}}); err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		if hash, ok := h.(string); ok {
+			accessTokenCache.delete(hash)
+		}
+	}
+	return nil
+}
+
+// Only tokens that exist are cached, so guessing at tokens can't fill the
+// cache. Revoked ones are cached with their revoked_at set.
+var accessTokenCache = newTTLCache[string, *models.AccessToken](sessionCacheTTL, 10000)
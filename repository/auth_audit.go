@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+	"time"
+)
+
+// RecordAuthEvent writes an audit event in the background so a slow write
+// doesn't slow down the response, which would hint at the outcome.
+func RecordAuthEvent(event models.AuthAuditEvent) {
+	event.CreatedAt = time.Now().Unix()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if _, err := database.GetAuthAuditCollection().InsertOne(ctx, event); err != nil {
+			log.Printf("Failed to record auth event %s: %v", event.Event, err)
+		}
+	}()
+}
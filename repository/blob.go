@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// How often AcquireBlob checks whether a deletion is done
+	blobRetryDelay = 200 * time.Millisecond
+	// A deletion running this long is assumed to have died with its
+	// process, and the blob is taken over as is
+	blobDeleteTimeout = 10 * time.Minute
+)
+
+// AcquireBlob records one more reference to the blob with the given digest,
+// creating the record on first use. If the blob is being deleted it waits
+// until the object is gone and then starts a new record, so the caller
+// always writes the object again.
+func AcquireBlob(ctx context.Context, digest, key string, size int64, contentType string) error {
+	collection := database.GetBlobCollection()
+
+	update := bson.M{
+		"$inc": bson.M{"ref_count": 1},
+		"$setOnInsert": bson.M{
+			"key":          key,
+			"size":         size,
+			"content_type": contentType,
+			"created_at":   time.Now().Unix(),
+		},
+	}
+
+	for {
+		// A record being deleted doesn't match, so the upsert fails on
+		// the duplicate _id rather than reviving it
+		_, err := collection.UpdateOne(ctx,
+			bson.M{"_id": digest, "deleting_at": bson.M{"$exists": false}},
+			update,
+			options.Update().SetUpsert(true),
+		)
+		if err == nil || !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+
+		cutoff := time.Now().Add(-blobDeleteTimeout).Unix()
+		if _, err := collection.UpdateOne(ctx,
+			bson.M{"_id": digest, "deleting_at": bson.M{"$lt": cutoff}},
+			bson.M{"$unset": bson.M{"deleting_at": ""}, "$set": bson.M{"ref_count": 0}},
+		); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(blobRetryDelay):
+		}
+	}
+}
+
+// ReleaseBlob drops one reference and returns how many are left. When none
+// are left the record is marked as being deleted, and the caller must
+// delete the object and then call FinishBlobDeletion.
+func ReleaseBlob(ctx context.Context, digest string) (int, error) {
+	collection := database.GetBlobCollection()
+
+	var blob models.Blob
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": digest},
+		bson.M{"$inc": bson.M{"ref_count": -1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&blob)
+	if err != nil {
+		return 0, err
+	}
+
+	if blob.RefCount <= 0 {
+		res, err := collection.UpdateOne(ctx,
+			bson.M{"_id": digest, "ref_count": bson.M{"$lte": 0}, "deleting_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"deleting_at": time.Now().Unix()}},
+		)
+		if err != nil {
+			return 0, err
+		}
+		if res.ModifiedCount == 0 {
+			// Someone acquired it again in between, keep the object.
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return blob.RefCount, nil
+}
+
+// FinishBlobDeletion removes the record of a blob whose object has been
+// deleted, which lets AcquireBlob create it afresh.
+func FinishBlobDeletion(ctx context.Context, digest string) error {
+	_, err := database.GetBlobCollection().DeleteOne(ctx, bson.M{"_id": digest, "deleting_at": bson.M{"$exists": true}})
+	return err
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"sync"
+	"time"
+)
+
+// ttlCache remembers values for a short time. It holds at most max
+// entries: when full, expired entries are dropped, and if that doesn't
+// free a good share of the room everything is, so a flood of keys can
+// neither grow it nor make every set scan it.
+type ttlCache[K comparable, V any] struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[K]ttlCacheEntry[V]
+}
+
+type ttlCacheEntry[V any] struct {
+	value   V
+	checked time.Time
+}
+
+func newTTLCache[K comparable, V any](ttl time.Duration, max int) *ttlCache[K, V] {
+	return &ttlCache[K, V]{ttl: ttl, max: max, entries: make(map[K]ttlCacheEntry[V])}
+}
+
+func (c *ttlCache[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || time.Since(e.checked) > c.ttl {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+func (c *ttlCache[K, V]) set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= c.max {
+		for k, e := range c.entries {
+			if time.Since(e.checked) > c.ttl {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) > c.max*3/4 {
+			clear(c.entries)
+		}
+	}
+	c.entries[key] = ttlCacheEntry[V]{value: value, checked: time.Now()}
+}
+
+func (c *ttlCache[K, V]) delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"photo-storage-backend/database"
+	"photo-storage-backend/models"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -34,3 +35,52 @@ func MarkAsEmbedded(ctx context.Context, userIDStr string, name string) error {
 	}
 	return err
 }
+
+// MigrateLegacyPhotoPaths rewrites paths stored before the storage package
+// existed ("uploads/<file>") into plain storage keys ("<file>").
+func MigrateLegacyPhotoPaths(ctx context.Context) error {
+	collection := database.GetPhotoCollection()
+
+	filter := bson.M{"path": bson.M{"$regex": "^uploads/"}}
+	update := bson.A{
+		bson.M{"$set": bson.M{"path": bson.M{"$substrCP": bson.A{"$path", 8, bson.M{"$strLenCP": "$path"}}}}},
+	}
+
+	res, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount > 0 {
+		log.Printf("Migrated %d legacy photo paths", res.ModifiedCount)
+	}
+	return nil
+}
+
+// SetRenditions records renditions on every photo backed by the object at
+// path, since content-addressed photos share their renditions.
+func SetRenditions(ctx context.Context, path string, renditions []models.Rendition) error {
+	collection := database.GetPhotoCollection()
+
+	_, err := collection.UpdateMany(ctx, bson.M{"path": path}, bson.M{
+		"$set": bson.M{"renditions": renditions},
+	})
+	return err
+}
+
+// BackfillTakenAt gives photos uploaded before EXIF extraction a taken_at
+// equal to their upload time, so sorting by taken_at includes them.
+func BackfillTakenAt(ctx context.Context) error {
+	collection := database.GetPhotoCollection()
+
+	filter := bson.M{"taken_at": bson.M{"$exists": false}}
+	update := bson.A{bson.M{"$set": bson.M{"taken_at": "$upload_at"}}}
+
+	res, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount > 0 {
+		log.Printf("Backfilled taken_at on %d photos", res.ModifiedCount)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+	"photo-storage-backend/utils"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// CreateSession starts a session for a login from the given device and
+// returns it with its first refresh token.
+func CreateSession(ctx context.Context, userID primitive.ObjectID, userAgent, ip string) (*models.Session, string, error) {
+	now := time.Now()
+	session := &models.Session{
+		ID:         primitive.NewObjectID(),
+		UserID:     userID,
+		CreatedAt:  now.Unix(),
+		ExpiresAt:  now.Add(utils.RefreshTokenTTL()).Unix(),
+		LastSeenAt: now.Unix(),
+		UserAgent:  userAgent,
+		IP:         ip,
+	}
+	if _, err := database.GetSessionCollection().InsertOne(ctx, session); err != nil {
+		return nil, "", err
+	}
+
+	token, err := issueRefreshToken(ctx, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return session, token, nil
+}
+
+func issueRefreshToken(ctx context.Context, session *models.Session) (string, error) {
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	doc := models.RefreshToken{
+		ID:        primitive.NewObjectID(),
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		TokenHash: utils.HashToken(token),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(utils.RefreshTokenTTL()),
+	}
+	if _, err := database.GetRefreshTokenCollection().InsertOne(ctx, doc); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken trades a refresh token for a new one in the same
+// session. A token can only be used once: presenting it again means it was
+// stolen, or the thief got there first, so the whole session is revoked.
+func RotateRefreshToken(ctx context.Context, token, userAgent, ip string) (*models.Session, string, error) {
+	tokens := database.GetRefreshTokenCollection()
+
+	var current models.RefreshToken
+	err := tokens.FindOne(ctx, bson.M{"token_hash": utils.HashToken(token)}).Decode(&current)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if time.Now().After(current.ExpiresAt) {
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	// Claim the token atomically so two concurrent refreshes can't both win
+	res, err := tokens.UpdateOne(ctx,
+		bson.M{"_id": current.ID, "used_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"used_at": time.Now().Unix()}},
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	if res.ModifiedCount == 0 {
+		if err := RevokeSession(ctx, current.SessionID, "refresh token reuse"); err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrRefreshTokenReused
+	}
+
+	var session models.Session
+	if err := database.GetSessionCollection().FindOne(ctx, bson.M{"_id": current.SessionID}).Decode(&session); err != nil {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if session.RevokedAt != 0 {
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	next, err := issueRefreshToken(ctx, &session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	session.ExpiresAt = now.Add(utils.RefreshTokenTTL()).Unix()
+	session.LastSeenAt = now.Unix()
+	session.UserAgent = userAgent
+	session.IP = ip
+	update := bson.M{"$set": bson.M{
+		"expires_at":   session.ExpiresAt,
+		"last_seen_at": session.LastSeenAt,
+		"user_agent":   userAgent,
+		"ip":           ip,
+	}}
+	if _, err := database.GetSessionCollection().UpdateByID(ctx, session.ID, update); err != nil {
+		return nil, "", err
+	}
+	lastSeen.mark(session.ID, now)
+	return &session, next, nil
+}
+
+// RevokeSession ends a session. Its refresh tokens stop working right away,
+// its access tokens as soon as every instance's cache has caught up.
+func RevokeSession(ctx context.Context, sessionID primitive.ObjectID, reason string) error {
+	_, err := database.GetSessionCollection().UpdateOne(ctx,
+		bson.M{"_id": sessionID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().Unix(), "revoked_reason": reason}},
+	)
+	if err != nil {
+		return err
+	}
+	sessionCache.set(sessionID, false)
+	return nil
+}
+
+// RevokeUserSessions ends all of the user's sessions except the one given,
+// which may be nil, and returns how many were ended.
+func RevokeUserSessions(ctx context.Context, userID primitive.ObjectID, except *primitive.ObjectID, reason string) (int, error) {
+	collection := database.GetSessionCollection()
+
+	filter := bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}}
+	if except != nil {
+		filter["_id"] = bson.M{"$ne": *except}
+	}
+
+	ids, err := collection.Distinct(ctx, "_id", filter)
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	_, err = collection.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().Unix(), "revoked_reason": reason}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			sessionCache.set(oid, false)
+		}
+	}
+	return len(ids), nil
+}
+
+// TouchSession records that the session was just used. To keep this off
+// the request path it writes at most once per sessionTouchInterval per
+// session and instance, in the background.
+func TouchSession(sessionID primitive.ObjectID, ip string) {
+	now := time.Now()
+	if !lastSeen.due(sessionID, now) {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		set := bson.M{"last_seen_at": now.Unix()}
+		if ip != "" {
+			set["ip"] = ip
+		}
+		if _, err := database.GetSessionCollection().UpdateByID(ctx, sessionID, bson.M{"$set": set}); err != nil {
+			log.Printf("Failed to update session last seen: %v", err)
+		}
+	}()
+}
+
+const sessionTouchInterval = 5 * time.Minute
+
+type lastSeenTracker struct {
+	mu    sync.Mutex
+	times map[primitive.ObjectID]time.Time
+}
+
+var lastSeen = &lastSeenTracker{times: make(map[primitive.ObjectID]time.Time)}
+
+// due reports whether the session's last-seen time should be written, and
+// if so counts it as written.
+func (t *lastSeenTracker) due(id primitive.ObjectID, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if last, ok := t.times[id]; ok && now.Sub(last) < sessionTouchInterval {
+		return false
+	}
+	if len(t.times) > 10000 {
+		for k, last := range t.times {
+			if now.Sub(last) >= sessionTouchInterval {
+				delete(t.times, k)
+			}
+		}
+	}
+	t.times[id] = now
+	return true
+}
+
+func (t *lastSeenTracker) mark(id primitive.ObjectID, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.times[id] = now
+}
+
+// SessionActive reports whether access tokens of the session are still
+// accepted. Answers are cached briefly so authenticating a request doesn't
+// always cost a database round trip.
+func SessionActive(ctx context.Context, sessionID primitive.ObjectID) (bool, error) {
+	if active, ok := sessionCache.get(sessionID); ok {
+		return active, nil
+	}
+
+	var session models.Session
+	err := database.GetSessionCollection().FindOne(ctx, bson.M{"_id": sessionID}).Decode(&session)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		sessionCache.set(sessionID, false)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	active := session.RevokedAt == 0
+	sessionCache.set(sessionID, active)
+	return active, nil
+}
+
+const sessionCacheTTL = 30 * time.Second
+
+var sessionCache = newTTLCache[primitive.ObjectID, bool](sessionCacheTTL, 10000)
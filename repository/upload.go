@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"os"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+	"photo-storage-backend/storage"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// uploadLocks keeps two requests from writing the same upload at once. The
+// temp files are local, so a local lock is enough. Locks are dropped when
+// the upload completes or is discarded.
+var uploadLocks sync.Map
+
+// LockUpload takes the upload's lock if no other request holds it, and
+// returns the function that releases it.
+func LockUpload(id primitive.ObjectID) (func(), bool) {
+	lock, _ := uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	if !mu.TryLock() {
+		return nil, false
+	}
+	return mu.Unlock, true
+}
+
+// DropUploadLock forgets the lock of an upload nobody will write again.
+func DropUploadLock(id primitive.ObjectID) {
+	uploadLocks.Delete(id)
+}
+
+// DiscardUpload drops an unfinished upload: its document, its temp file,
+// its lock and the quota reserved for it. It reports false if the upload was no
+// longer unfinished, e.g. because it completed in the meantime.
+func DiscardUpload(ctx context.Context, upload *models.Upload) (bool, error) {
+	res, err := database.GetUploadCollection().DeleteOne(ctx,
+		bson.M{"_id": upload.ID, "status": models.UploadStatusUploading})
+	if err != nil {
+		return false, err
+	}
+	if res.DeletedCount == 0 {
+		return false, nil
+	}
+	DropUploadLock(upload.ID)
+
+	if err := os.Remove(storage.UploadPath(upload.ID.Hex())); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove upload file %s: %v", upload.ID.Hex(), err)
+	}
+	if err := AddUsage(ctx, upload.UserID, -upload.Length, -1); err != nil {
+		log.Printf("Failed to release quota of upload %s: %v", upload.ID.Hex(), err)
+	}
+	return true, nil
+}
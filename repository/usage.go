@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"os"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Quota limits a user's storage. Zero means no limit.
+type Quota struct {
+	Bytes  int64 `json:"bytes"`
+	Photos int64 `json:"photos"`
+}
+
+// QuotaFor returns the user's quota: their own override if an admin set
+// one, otherwise QUOTA_BYTES and QUOTA_PHOTOS.
+func QuotaFor(user *models.User) Quota {
+	return Quota{
+		Bytes:  quotaLimit(user.QuotaBytes, "QUOTA_BYTES"),
+		Photos: quotaLimit(user.QuotaPhotos, "QUOTA_PHOTOS"),
+	}
+}
+
+func quotaLimit(override int64, env string) int64 {
+	switch {
+	case override < 0:
+		return 0
+	case override > 0:
+		return override
+	}
+	n, err := strconv.ParseInt(os.Getenv(env), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// Allows reports whether bytes and photos more fit on top of usage.
+func (q Quota) Allows(usage models.UserUsage, bytes, photos int64) bool {
+	return (q.Bytes == 0 || usage.Bytes+bytes <= q.Bytes) &&
+		(q.Photos == 0 || usage.Photos+photos <= q.Photos)
+}
+
+// ReserveUsage adds to the user's usage if it stays within quota, in one
+// atomic update so concurrent uploads can't both squeeze in. Reservations
+// that end up unused are given back with AddUsage.
+func ReserveUsage(ctx context.Context, userID primitive.ObjectID, quota Quota, bytes, photos int64) (bool, error) {
+	filter := bson.M{"_id": userID}
+
+	var limits bson.A
+	if quota.Bytes > 0 {
+		limits = append(limits, bson.M{"$lte": bson.A{bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$usage.bytes", 0}}, bytes}}, quota.Bytes}})
+	}
+	if quota.Photos > 0 {
+		limits = append(limits, bson.M{"$lte": bson.A{bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$usage.photos", 0}}, photos}}, quota.Photos}})
+	}
+	if len(limits) > 0 {
+		filter["$expr"] = bson.M{"$and": limits}
+	}
+
+	res, err := database.GetUserCollection().UpdateOne(ctx, filter,
+		bson.M{"$inc": bson.M{"usage.bytes": bytes, "usage.photos": photos}})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// AddUsage changes the user's usage without checking the quota. Negative
+// values give space back.
+func AddUsage(ctx context.Context, userID primitive.ObjectID, bytes, photos int64) error {
+	if bytes == 0 && photos == 0 {
+		return nil
+	}
+	_, err := database.GetUserCollection().UpdateByID(ctx, userID,
+		bson.M{"$inc": bson.M{"usage.bytes": bytes, "usage.photos": photos}})
+	return err
+}
+
+// RecountUsage sets the user's usage from their photos, for when the
+// counters can't be trusted to add up. Resumable uploads still in flight
+// keep what they reserved.
+func RecountUsage(ctx context.Context, userID primitive.ObjectID) error {
+	usage, err := countUsage(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return err
+	}
+	reserved, err := countReservedUsage(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	total := usage[userID]
+	total.Bytes += reserved.Bytes
+	total.Photos += reserved.Photos
+	_, err = database.GetUserCollection().UpdateByID(ctx, userID,
+		bson.M{"$set": bson.M{"usage": total}})
+	return err
+}
+
+// countReservedUsage adds up the reservations of the user's resumable
+// uploads that have not become photos yet.
+func countReservedUsage(ctx context.Context, userID primitive.ObjectID) (models.UserUsage, error) {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{
+			"user_id": userID,
+			"status":  bson.M{"$in": bson.A{models.UploadStatusUploading, models.UploadStatusProcessing}},
+		}},
+		bson.M{"$group": bson.M{
+			"_id":    nil,
+			"bytes":  bson.M{"$sum": "$length"},
+			"photos": bson.M{"$sum": 1},
+		}},
+	}
+	cursor, err := database.GetUploadCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return models.UserUsage{}, err
+	}
+
+	var rows []models.UserUsage
+	if err := cursor.All(ctx, &rows); err != nil {
+		return models.UserUsage{}, err
+	}
+	if len(rows) == 0 {
+		return models.UserUsage{}, nil
+	}
+	return rows[0], nil
+}
+
+// BackfillUsage counts the usage of users from before usage accounting.
+func BackfillUsage(ctx context.Context) error {
+	users := database.GetUserCollection()
+
+	ids, err := users.Distinct(ctx, "_id", bson.M{"usage": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	usage, err := countUsage(ctx, bson.M{"user_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		userID, ok := id.(primitive.ObjectID)
+		if !ok {
+			continue
+		}
+		if _, err := users.UpdateOne(ctx,
+			bson.M{"_id": userID, "usage": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"usage": usage[userID]}},
+		); err != nil {
+			return err
+		}
+	}
+	log.Printf("Backfilled storage usage of %d users", len(ids))
+	return nil
+}
+
+func countUsage(ctx context.Context, match bson.M) (map[primitive.ObjectID]models.UserUsage, error) {
+	pipeline := bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{
+			"_id":    "$user_id",
+			"bytes":  bson.M{"$sum": "$size"},
+			"photos": bson.M{"$sum": 1},
+		}},
+	}
+	cursor, err := database.GetPhotoCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		UserID primitive.ObjectID `bson:"_id"`
+		Bytes  int64              `bson:"bytes"`
+		Photos int64              `bson:"photos"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	usage := make(map[primitive.ObjectID]models.UserUsage, len(rows))
+	for _, r := range rows {
+		usage[r.UserID] = models.UserUsage{Bytes: r.Bytes, Photos: r.Photos}
+	}
+	return usage, nil
+}
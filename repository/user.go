@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"os"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// BootstrapAdmins makes the accounts listed in ADMIN_EMAILS admins, so the
+// first admin doesn't need a Mongo shell. Only verified addresses count,
+// or anyone could register one of them and wait.
+func BootstrapAdmins(ctx context.Context) error {
+	var emails []string
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	if len(emails) == 0 {
+		return nil
+	}
+
+	res, err := database.GetUserCollection().UpdateMany(ctx,
+		bson.M{"email": bson.M{"$in": emails}, "email_verified": true, "role": bson.M{"$ne": models.UserRoleAdmin}},
+		bson.M{"$set": bson.M{"role": models.UserRoleAdmin}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount > 0 {
+		log.Printf("Made %d account(s) from ADMIN_EMAILS admin", res.ModifiedCount)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"photo-storage-backend/database"
+	"photo-storage-backend/models"
+	"photo-storage-backend/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrInvalidUserToken = errors.New("invalid or expired token")
+
+// CreateUserToken issues a token for purpose, replacing any unused one the
+// user already has for it, so only the latest email works.
+func CreateUserToken(ctx context.Context, userID primitive.ObjectID, purpose, email string, ttl time.Duration) (string, error) {
+	collection := database.GetUserTokenCollection()
+
+	if _, err := collection.DeleteMany(ctx, bson.M{
+		"user_id": userID,
+		"purpose": purpose,
+		"used_at": bson.M{"$exists": false},
+	}); err != nil {
+		return "", err
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	doc := models.UserToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Purpose:   purpose,
+		Email:     email,
+		TokenHash: utils.HashToken(token),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(ttl),
+	}
+	if _, err := collection.InsertOne(ctx, doc); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// DeleteUserTokens drops the user's unused tokens for the given purposes,
+// e.g. links mailed to an address the account no longer has.
+func DeleteUserTokens(ctx context.Context, userID primitive.ObjectID, purposes ...string) error {
+	_, err := database.GetUserTokenCollection().DeleteMany(ctx, bson.M{
+		"user_id": userID,
+		"purpose": bson.M{"$in": purposes},
+		"used_at": bson.M{"$exists": false},
+	})
+	return err
+}
+
+// ConsumeUserToken marks a token used and returns it. Each token works
+// once, and only for the purpose it was issued for.
+func ConsumeUserToken(ctx context.Context, token, purpose string) (*models.UserToken, error) {
+	var doc models.UserToken
+	err := database.GetUserTokenCollection().FindOneAndUpdate(ctx,
+		bson.M{
+			"token_hash": utils.HashToken(token),
+			"purpose":    purpose,
+			"used_at":    bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": time.Now()},
+		},
+		bson.M{"$set": bson.M{"used_at": time.Now().Unix()}},
+	).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidUserToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
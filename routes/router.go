@@ -3,25 +3,129 @@ package routes
 import (
 	"photo-storage-backend/handlers"
 	"photo-storage-backend/middleware"
+	"photo-storage-backend/models"
 
 	"github.com/gin-gonic/gin"
 )
 
 func SetupRoutes(r *gin.Engine) {
+	r.GET("/.well-known/jwks.json", handlers.JWKS)
+
 	api := r.Group("/api")
 
 	// Public routes
 	api.POST("/register", handlers.Register)
 	api.POST("/login", handlers.Login)
+	api.POST("/login/mfa", handlers.LoginMFA)
+	api.POST("/token/refresh", handlers.RefreshToken)
+	api.POST("/verify-email/confirm", handlers.ConfirmEmailVerification)
+	api.POST("/password/forgot", handlers.ForgotPassword)
+	api.POST("/password/reset", handlers.ResetPassword)
+	api.GET("/auth/oidc", handlers.ListOIDCProviders)
+	api.GET("/auth/oidc/:provider", handlers.OIDCLogin)
+	api.GET("/auth/oidc/:provider/callback", handlers.OIDCCallback)
+
+	// Protected routes. Personal access tokens only get the routes their
+	// scopes allow, and none of the account settings.
+	session := middleware.RequireSession()
+	photosRead := middleware.RequireScope(models.ScopePhotosRead)
+	photosWrite := middleware.RequireScope(models.ScopePhotosWrite)
+	search := middleware.RequireScope(models.ScopeSearch)
+	albumsRead := middleware.RequireScope(models.ScopeAlbumsRead)
+	albumsWrite := middleware.RequireScope(models.ScopeAlbumsWrite)
 
-	// Protected routes
 	apiAuth := api.Group("/")
 	apiAuth.Use(middleware.AuthMiddleware())
 	{
-		apiAuth.POST("/upload", handlers.UploadPhotos)
-		apiAuth.GET("/photos", handlers.ListPhotos)
-		apiAuth.GET("/search", handlers.SearchPhotos)
-		apiAuth.GET("/notification", handlers.GetNotifications)
-		apiAuth.POST("/notification", handlers.MarkNotificationsRead)
+		apiAuth.POST("/logout", session, handlers.Logout)
+		apiAuth.GET("/me", handlers.GetMe)
+		apiAuth.GET("/me/usage", photosRead, handlers.GetMyUsage)
+		apiAuth.PATCH("/me", session, handlers.UpdateMe)
+		apiAuth.DELETE("/me", session, handlers.DeleteMe)
+		apiAuth.GET("/sessions", session, handlers.ListSessions)
+		apiAuth.DELETE("/sessions/:id", session, handlers.RevokeSession)
+		apiAuth.POST("/sessions/revoke-others", session, handlers.RevokeOtherSessions)
+		apiAuth.GET("/tokens", session, handlers.ListAccessTokens)
+		apiAuth.POST("/tokens", session, handlers.CreateAccessToken)
+		apiAuth.DELETE("/tokens/:id", session, handlers.RevokeAccessToken)
+		apiAuth.POST("/verify-email/request", session, handlers.RequestEmailVerification)
+		apiAuth.POST("/mfa/enroll", session, handlers.EnrollMFA)
+		apiAuth.POST("/mfa/confirm", session, handlers.ConfirmMFA)
+		apiAuth.POST("/mfa/disable", session, handlers.DisableMFA)
+		apiAuth.POST("/mfa/recovery-codes", session, handlers.RegenerateRecoveryCodes)
+		apiAuth.POST("/upload", photosWrite, middleware.RequireVerifiedEmail(), handlers.UploadPhotos)
+		apiAuth.GET("/photos", photosRead, handlers.ListPhotos)
+		apiAuth.POST("/photos/check", photosRead, handlers.CheckPhotos)
+		apiAuth.DELETE("/photos/:id", photosWrite, handlers.DeletePhoto)
+		apiAuth.POST("/photos/trash", photosWrite, handlers.TrashPhotos)
+		apiAuth.POST("/photos/tags", photosWrite, handlers.AddTags)
+		apiAuth.POST("/photos/tags/remove", photosWrite, handlers.RemoveTags)
+		apiAuth.PUT("/photos/favorite", photosWrite, handlers.SetFavorite)
+		apiAuth.GET("/tags", photosRead, handlers.ListTags)
+		apiAuth.GET("/trash", photosRead, handlers.ListTrash)
+		apiAuth.POST("/trash/restore", photosWrite, handlers.RestorePhotos)
+		apiAuth.POST("/trash/purge", photosWrite, handlers.PurgeTrash)
+		apiAuth.GET("/search", search, handlers.SearchPhotos)
+		apiAuth.GET("/albums", albumsRead, handlers.ListAlbums)
+		apiAuth.POST("/albums", albumsWrite, handlers.CreateAlbum)
+		apiAuth.GET("/albums/shared", albumsRead, handlers.ListSharedAlbums)
+		apiAuth.GET("/albums/:id", albumsRead, handlers.GetAlbum)
+		apiAuth.PATCH("/albums/:id", albumsWrite, handlers.UpdateAlbum)
+		apiAuth.DELETE("/albums/:id", albumsWrite, handlers.DeleteAlbum)
+		apiAuth.POST("/albums/:id/photos", albumsWrite, handlers.AddAlbumPhotos)
+		apiAuth.POST("/albums/:id/photos/remove", albumsWrite, handlers.RemoveAlbumPhotos)
+		apiAuth.PUT("/albums/:id/order", albumsWrite, handlers.ReorderAlbum)
+		apiAuth.POST("/albums/:id/members", session, handlers.AddAlbumMember)
+		apiAuth.PATCH("/albums/:id/members/:userId", session, handlers.UpdateAlbumMember)
+		apiAuth.DELETE("/albums/:id/members/:userId", session, handlers.RemoveAlbumMember)
+		apiAuth.GET("/shares", session, handlers.ListShares)
+		apiAuth.POST("/shares", session, handlers.CreateShare)
+		apiAuth.DELETE("/shares/:id", session, handlers.RevokeShare)
+		apiAuth.GET("/notification", photosRead, handlers.GetNotifications)
+		apiAuth.POST("/notification", photosRead, handlers.MarkNotificationsRead)
+	}
+
+	// Resumable uploads (tus 1.0)
+	api.OPTIONS("/tus", handlers.TusResumable(), handlers.TusOptions)
+	tus := api.Group("/tus")
+	tus.Use(handlers.TusResumable(), middleware.AuthMiddleware(), photosWrite, middleware.RequireVerifiedEmail())
+	{
+		tus.POST("", handlers.CreateTusUpload)
+		tus.HEAD("/:id", handlers.HeadTusUpload)
+		tus.GET("/:id", handlers.GetTusUpload)
+		tus.PATCH("/:id", handlers.PatchTusUpload)
+		tus.DELETE("/:id", handlers.DeleteTusUpload)
+	}
+
+	// Admin API for the ops team, interactive sessions only
+	admin := api.Group("/admin")
+	admin.Use(middleware.AuthMiddleware(), session, middleware.RequireRole(models.UserRoleAdmin))
+	{
+		admin.GET("/users", handlers.AdminListUsers)
+		admin.GET("/users/:id", handlers.AdminGetUser)
+		admin.PUT("/users/:id/role", handlers.AdminSetRole)
+		admin.PUT("/users/:id/quota", handlers.AdminSetQuota)
+		admin.POST("/users/:id/disable", handlers.AdminDisableUser)
+		admin.POST("/users/:id/enable", handlers.AdminEnableUser)
+		admin.POST("/users/:id/password-reset", handlers.AdminForcePasswordReset)
+		admin.POST("/users/:id/reembed", handlers.AdminReembedUser)
+		admin.GET("/usage", handlers.AdminStorageUsage)
+	}
+
+	// Photo files, loaded by the browser directly so they also accept
+	// signed URLs instead of the Authorization header
+	photoFiles := api.Group("/photos/:id")
+	photoFiles.Use(middleware.SignedURLOrAuth(), photosRead)
+	{
+		photoFiles.GET("/content", handlers.GetPhotoContent)
+		photoFiles.GET("/thumbnail", handlers.GetThumbnail)
+	}
+
+	// Public share links, no account needed
+	shared := r.Group("/s/:token")
+	{
+		shared.GET("", handlers.GetShare)
+		shared.GET("/photos/:photoId/content", handlers.GetSharedPhotoContent)
+		shared.GET("/photos/:photoId/thumbnail", handlers.GetSharedThumbnail)
 	}
 }
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+)
+
+// StagedFile is an upload spooled to local temp disk while its SHA-256 is
+// computed, so it can be stored under its digest without a second read of the
+// client stream.
+type StagedFile struct {
+	File   *os.File
+	Digest string
+	Size   int64
+}
+
+func Stage(r io.Reader) (*StagedFile, error) {
+	f, err := os.CreateTemp("", "photo-upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(f, io.TeeReader(r, h))
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return &StagedFile{File: f, Digest: hex.EncodeToString(h.Sum(nil)), Size: n}, nil
+}
+
+// Close removes the temp file.
+func (f *StagedFile) Close() error {
+	f.File.Close()
+	return os.Remove(f.File.Name())
+}
+
+// BlobKey is the content-addressed key for a SHA-256 hex digest, fanned out
+// so no single directory or prefix grows too large.
+func BlobKey(digest string) string {
+	return "blobs/" + digest[:2] + "/" + digest[2:4] + "/" + digest
+}
+
+// PutStaged stores f under its BlobKey unless an object with that key is
+// already there, and returns the key.
+func PutStaged(ctx context.Context, store BlobStore, f *StagedFile, contentType string) (string, error) {
+	key := BlobKey(f.Digest)
+
+	_, err := store.Stat(ctx, key)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return "", err
+	}
+
+	if _, err := f.File.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return key, store.Put(ctx, key, f.File, f.Size, contentType)
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("storage: object not found")
+
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+	ETag        string
+}
+
+// BlobStore is where photo bytes live. Keys are slash separated and relative,
+// e.g. "1718000000_cat.jpg"; drivers decide how they map to files or objects.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, ObjectInfo, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+
+	// URI is how services outside this process (the inference worker) should
+	// locate the object, e.g. "uploads/<key>" or "s3://bucket/<key>".
+	URI(key string) string
+}
+
+var store BlobStore
+
+func InitStorage() {
+	driver := os.Getenv("STORAGE_DRIVER")
+	if driver == "" {
+		driver = "local"
+	}
+
+	var err error
+	switch driver {
+	case "local":
+		dir := os.Getenv("STORAGE_LOCAL_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		store, err = NewLocalStore(dir)
+	case "s3":
+		store, err = NewS3Store(S3Config{
+			Endpoint:    os.Getenv("S3_ENDPOINT"),
+			Region:      os.Getenv("S3_REGION"),
+			Bucket:      os.Getenv("S3_BUCKET"),
+			AccessKey:   os.Getenv("S3_ACCESS_KEY"),
+			SecretKey:   os.Getenv("S3_SECRET_KEY"),
+			VirtualHost: os.Getenv("S3_VIRTUAL_HOST") == "true",
+		})
+	default:
+		err = errors.New("unknown STORAGE_DRIVER " + driver)
+	}
+	if err != nil {
+		log.Fatal("Storage init failed:", err)
+	}
+
+	if err := os.MkdirAll(UploadDir(), 0o755); err != nil {
+		log.Fatal("Upload dir init failed:", err)
+	}
+}
+
+func GetStore() BlobStore {
+	return store
+}
+
+// validKey rejects keys that could escape the store root or the bucket prefix.
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// UploadDir is where resumable uploads collect their chunks until they are
+// complete. Every instance serving /api/tus needs to see the same directory,
+// or the load balancer has to keep an upload on one instance.
+func UploadDir() string {
+	dir := os.Getenv("TUS_UPLOAD_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "photo-uploads")
+	}
+	return dir
+}
+
+// UploadPath is the temp file of one resumable upload.
+func UploadPath(id string) string {
+	return filepath.Join(UploadDir(), id)
+}
@@ -1,48 +1,342 @@
 package utils
 
 import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// var jwtKey []byte
+// Tokens are signed with one key and verified with any key in the ring,
+// picked by the kid header, so secrets can be rotated without logging
+// everyone out. Configuration:
+//
+//	JWT_SECRET            HMAC secret (HS256)
+//	JWT_PRIVATE_KEY_FILE  PEM RSA or Ed25519 key, signs instead of JWT_SECRET
+//	JWT_KEY_ID            kid of the signing key
+//	JWT_PREVIOUS_SECRETS  kid:secret,... still accepted, never used to sign
+//	JWT_PUBLIC_KEY_FILES  kid:path,... still accepted, never used to sign
+//
+// Public keys are published at /.well-known/jwks.json.
 
-// func init() {
-// 	secret := os.Getenv("JWT_SECRET")
-// 	if secret == "" {
-// 		log.Fatal("JWT_SECRET is not set in environment")
-// 	}
+type jwtKey struct {
+	kid    string
+	method jwt.SigningMethod
+	sign   interface{} // nil for verification-only keys
+	verify interface{}
+}
+
+var (
+	signingKey *jwtKey
+	jwtKeys    = map[string]*jwtKey{}
+
+	// verifies tokens issued before kid headers were added
+	legacyKey *jwtKey
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// InitJWT loads the key ring from the environment. It must run before any
+// token is issued or checked.
+func InitJWT() {
+	if err := loadJWTKeys(); err != nil {
+		log.Fatal("JWT keys: ", err)
+	}
+	if os.Getenv("URL_SIGNING_SECRET") == "" && os.Getenv("JWT_SECRET") == "" {
+		log.Fatal("URL_SIGNING_SECRET is required when JWT_SECRET is not set")
+	}
+	log.Printf("JWT signing with %s key %q (%d keys accepted)", signingKey.method.Alg(), signingKey.kid, len(jwtKeys))
+}
+
+func loadJWTKeys() error {
+	keyID := os.Getenv("JWT_KEY_ID")
+
+	if path := os.Getenv("JWT_PRIVATE_KEY_FILE"); path != "" {
+		pemData, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		key, err := parsePrivateKey(pemData)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if keyID == "" {
+			keyID = keyThumbprint(key.verify)
+		}
+		key.kid = keyID
+		signingKey = key
+		jwtKeys[key.kid] = key
+	}
+
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		kid := keyID
+		if signingKey != nil || kid == "" {
+			kid = "hs256"
+		}
+		key := &jwtKey{kid: kid, method: jwt.SigningMethodHS256, verify: []byte(secret)}
+		if signingKey == nil {
+			key.sign = key.verify
+			signingKey = key
+		}
+		if _, taken := jwtKeys[kid]; taken {
+			return fmt.Errorf("duplicate kid %q", kid)
+		}
+		jwtKeys[kid] = key
+		legacyKey = key
+	}
+
+	if signingKey == nil {
+		return errors.New("set JWT_SECRET or JWT_PRIVATE_KEY_FILE")
+	}
+
+	for _, entry := range splitList(os.Getenv("JWT_PREVIOUS_SECRETS")) {
+		kid, secret, ok := strings.Cut(entry, ":")
+		if !ok || kid == "" || secret == "" {
+			return fmt.Errorf("JWT_PREVIOUS_SECRETS: expected kid:secret, got %q", entry)
+		}
+		if _, taken := jwtKeys[kid]; taken {
+			return fmt.Errorf("duplicate kid %q", kid)
+		}
+		jwtKeys[kid] = &jwtKey{kid: kid, method: jwt.SigningMethodHS256, verify: []byte(secret)}
+	}
+
+	for _, entry := range splitList(os.Getenv("JWT_PUBLIC_KEY_FILES")) {
+		kid, path, ok := strings.Cut(entry, ":")
+		if !ok || kid == "" || path == "" {
+			return fmt.Errorf("JWT_PUBLIC_KEY_FILES: expected kid:path, got %q", entry)
+		}
+		if _, taken := jwtKeys[kid]; taken {
+			return fmt.Errorf("duplicate kid %q", kid)
+		}
+		pemData, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		key, err := parsePublicKey(pemData)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		key.kid = kid
+		jwtKeys[kid] = key
+	}
+	return nil
+}
+
+func parsePrivateKey(pemData []byte) (*jwtKey, error) {
+	if k, err := jwt.ParseRSAPrivateKeyFromPEM(pemData); err == nil {
+		return &jwtKey{method: jwt.SigningMethodRS256, sign: k, verify: &k.PublicKey}, nil
+	}
+	if k, err := jwt.ParseEdPrivateKeyFromPEM(pemData); err == nil {
+		edKey := k.(ed25519.PrivateKey)
+		return &jwtKey{method: jwt.SigningMethodEdDSA, sign: edKey, verify: edKey.Public()}, nil
+	}
+	return nil, errors.New("not an RSA or Ed25519 private key")
+}
+
+func parsePublicKey(pemData []byte) (*jwtKey, error) {
+	if k, err := jwt.ParseRSAPublicKeyFromPEM(pemData); err == nil {
+		return &jwtKey{method: jwt.SigningMethodRS256, verify: k}, nil
+	}
+	if k, err := jwt.ParseEdPublicKeyFromPEM(pemData); err == nil {
+		return &jwtKey{method: jwt.SigningMethodEdDSA, verify: k}, nil
+	}
+	return nil, errors.New("not an RSA or Ed25519 public key")
+}
+
+// keyThumbprint derives a stable kid from a public key.
+func keyThumbprint(pub crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "default"
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8])
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// AccessTokenTTL is how long access tokens live. They can't be revoked
+// instantly (see repository.SessionActive), so keep it short.
+func AccessTokenTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 15 * time.Minute
+}
+
+// RefreshTokenTTL is how long a session survives without being used.
+func RefreshTokenTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 30 * 24 * time.Hour
+}
 
-// 	jwtKey = []byte(secret)
-// }
+// TokenClaims is what the API needs from an access token.
+type TokenClaims struct {
+	UserID    string
+	SessionID string // empty for tokens issued before sessions existed
+}
+
+// Token types, in the typ claim. Tokens without one are access tokens
+// issued before the claim existed.
+const (
+	tokenTypeAccess     = "access"
+	tokenTypeMFAPending = "mfa_pending"
+)
 
-var jwtKey = []byte("my-32-character-ultra-secure-and-ultra-long-secret")
+// MFATokenTTL is how long a user has to enter their second factor after
+// the password step.
+const MFATokenTTL = 5 * time.Minute
 
-func GenerateToken(userID string) (string, error) {
-	claims := jwt.MapClaims{
+// GenerateToken issues an access token for a session.
+func GenerateToken(userID, sessionID string) (string, error) {
+	return signToken(jwt.MapClaims{
 		"user_id": userID,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
+		"sid":     sessionID,
+		"typ":     tokenTypeAccess,
+		"exp":     time.Now().Add(AccessTokenTTL()).Unix(),
+	})
+}
+
+// GenerateMFAToken issues the token a user gets after the password step
+// when they have two-factor auth enabled. It only works with
+// ParseMFAToken, never as an access token.
+func GenerateMFAToken(userID string) (string, error) {
+	// Its ID lets attempts be counted per token
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", err
 	}
+	return signToken(jwt.MapClaims{
+		"user_id": userID,
+		"jti":     jti,
+		"typ":     tokenTypeMFAPending,
+		"exp":     time.Now().Add(MFATokenTTL).Unix(),
+	})
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+func signToken(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(signingKey.method, claims)
+	token.Header["kid"] = signingKey.kid
+	return token.SignedString(signingKey.sign)
 }
 
-func ParseToken(tokenStr string) (string, error) {
+func ParseToken(tokenStr string) (*TokenClaims, error) {
+	claims, err := parseClaims(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if typ, _ := claims["typ"].(string); typ != "" && typ != tokenTypeAccess {
+		return nil, errors.New("not an access token")
+	}
+	uid, _ := claims["user_id"].(string)
+	if uid == "" {
+		return nil, errors.New("token has no user_id")
+	}
+	sid, _ := claims["sid"].(string)
+
+	return &TokenClaims{UserID: uid, SessionID: sid}, nil
+}
+
+// ParseMFAToken checks a token from GenerateMFAToken and returns its user
+// and its ID.
+func ParseMFAToken(tokenStr string) (userID, tokenID string, err error) {
+	claims, err := parseClaims(tokenStr)
+	if err != nil {
+		return "", "", err
+	}
+	if typ, _ := claims["typ"].(string); typ != tokenTypeMFAPending {
+		return "", "", errors.New("not an MFA token")
+	}
+	uid, _ := claims["user_id"].(string)
+	jti, _ := claims["jti"].(string)
+	if uid == "" || jti == "" {
+		return "", "", errors.New("token has no user_id or jti")
+	}
+	return uid, jti, nil
+}
+
+func parseClaims(tokenStr string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
+		key := legacyKey
+		if kid, ok := t.Header["kid"].(string); ok {
+			key = jwtKeys[kid]
+		}
+		if key == nil {
+			return nil, ErrUnknownKey
+		}
+		// The key decides the algorithm, never the token
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+		return key.verify, nil
 	})
 
-	if err != nil || !token.Valid {
-		return "", err
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("unexpected claims")
 	}
+	return claims, nil
+}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		if uid, ok := claims["user_id"].(string); ok {
-			return uid, nil
+// JWKS returns the public verification keys as a JSON Web Key Set. HMAC
+// secrets are never published.
+func JWKS() map[string]interface{} {
+	keys := []map[string]interface{}{}
+	for _, k := range jwtKeys {
+		jwk := map[string]interface{}{
+			"kid": k.kid,
+			"alg": k.method.Alg(),
+			"use": "sig",
+		}
+		switch pub := k.verify.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(bigEndian(pub.E))
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
 		}
+		keys = append(keys, jwk)
 	}
+	return map[string]interface{}{"keys": keys}
+}
 
-	return "", err
+func bigEndian(n int) []byte {
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return b
 }
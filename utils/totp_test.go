@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors from RFC 6238 appendix B, cut to our 6 digits. The
+// secret is the ASCII string "12345678901234567890".
+func TestValidateTOTPVectors(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		step, ok := ValidateTOTP(secret, tt.code, time.Unix(tt.unix, 0))
+		if !ok {
+			t.Errorf("code %s at %d rejected", tt.code, tt.unix)
+			continue
+		}
+		if want := tt.unix / totpPeriod; step != want {
+			t.Errorf("code %s at %d matched step %d, want %d", tt.code, tt.unix, step, want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	at := time.Unix(1111111111, 0) // code 050471, step 37037037
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		t      time.Time
+		ok     bool
+	}{
+		{"exact", secret, "050471", at, true},
+		{"surrounding spaces", secret, " 050471 ", at, true},
+		{"lower case secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "050471", at, true},
+		{"one step early", secret, "050471", at.Add(-totpPeriod * time.Second), true},
+		{"one step late", secret, "050471", at.Add(totpPeriod * time.Second), true},
+		{"two steps late", secret, "050471", at.Add(2 * totpPeriod * time.Second), false},
+		{"wrong code", secret, "050472", at, false},
+		{"too short", secret, "50471", at, false},
+		{"8 digits", secret, "14050471", at, false},
+		{"bad secret", "not base32!", "050471", at, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(tt.secret, tt.code, tt.t); ok != tt.ok {
+				t.Errorf("ok = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}